@@ -0,0 +1,205 @@
+package exchange
+
+import "encoding/json"
+import "errors"
+import htmltemplate "html/template"
+import "net/mail"
+import "os"
+import "strconv"
+import "sync"
+import "time"
+
+// The severities of operator-alerts, from the least to the most important.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+var severityLevels = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityError: 2}
+
+// The kinds of sinks operator-alerts can be sent to.
+const (
+	AlertSinkWebhook = "webhook" // Posts the MessageWebhook and its severity as json
+	AlertSinkSlack   = "slack"
+	AlertSinkEmail   = "email"
+	AlertSinkLog     = "log" // Only prints the alert
+)
+
+// AlertSink - This is the struct which holds one place operator-alerts are sent.
+type AlertSink struct {
+	Type        string `json:"type" yaml:"type"`
+	URL         string `json:"url,omitempty" yaml:"url"`                 // For webhook and slack
+	Email       string `json:"email,omitempty" yaml:"email"`             // For email
+	MinSeverity string `json:"minSeverity,omitempty" yaml:"minSeverity"` // Alerts less important than this are not sent to the sink, info if empty
+}
+
+// AlertPayload - This is the struct which is posted to webhook-sinks.
+type AlertPayload struct {
+	MessageWebhook
+	Severity string `json:"severity"`
+}
+
+// Alerter sends operator-alerts to every sink that wants the severity, and retries a failed sink Retries times.
+type Alerter struct {
+	Sinks      []AlertSink
+	Retries    int
+	RetryDelay time.Duration // Doubled for every retry
+	QueueSize  int           // How many alerts can wait to be sent by Queue, 100 if 0
+
+	start   sync.Once
+	queue   chan queuedAlert
+	pending sync.WaitGroup
+}
+
+type queuedAlert struct {
+	severity string
+	msg      MessageWebhook
+}
+
+// Alerts is the alerter used by SendMessageWebhook. It only logs the alerts before sinks are configured with ALERT_SINKS.
+var Alerts = &Alerter{Sinks: []AlertSink{{Type: AlertSinkLog}}, Retries: 3, RetryDelay: time.Second}
+
+// AlertSinksFromEnv reads the sinks from ALERT_SINKS, a json-list like [{"type": "slack", "url": "https://hooks.slack.com/...", "minSeverity": "error"}].
+// Only a log-sink is returned if ALERT_SINKS isn't set.
+func AlertSinksFromEnv() ([]AlertSink, error) {
+	value := os.Getenv("ALERT_SINKS")
+	if value == "" {
+		return []AlertSink{{Type: AlertSinkLog}}, nil
+	}
+	sinks := []AlertSink{}
+	if err := json.Unmarshal([]byte(value), &sinks); err != nil {
+		return nil, errors.New("ALERT_SINKS: " + err.Error())
+	}
+	for i, sink := range sinks {
+		if err := sink.validate(); err != nil {
+			return nil, errors.New("ALERT_SINKS: sink " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	return sinks, nil
+}
+
+func (sink AlertSink) validate() error {
+	if _, ok := severityLevels[sink.MinSeverity]; sink.MinSeverity != "" && !ok {
+		return errors.New("minSeverity must be info, warning or error, not '" + sink.MinSeverity + "'")
+	}
+	switch sink.Type {
+	case AlertSinkWebhook, AlertSinkSlack:
+		if sink.URL == "" {
+			return errors.New("url is required for type " + sink.Type)
+		}
+		if problem := checkChatURL(WebhookKindSlack, sink.URL); sink.Type == AlertSinkSlack && problem != "" {
+			return errors.New("url " + problem)
+		}
+	case AlertSinkEmail:
+		if _, err := mail.ParseAddress(sink.Email); err != nil {
+			return errors.New("email is not a valid email-address")
+		}
+	case AlertSinkLog:
+	default:
+		return errors.New("type must be webhook, slack, email or log, not '" + sink.Type + "'")
+	}
+	return nil
+}
+
+// Wants tells if the sink should get alerts with severity.
+func (sink AlertSink) Wants(severity string) bool {
+	return severityLevels[severity] >= severityLevels[sink.MinSeverity]
+}
+
+// Send sends msg to every sink that wants severity, and returns false if any of them failed after all retries.
+func (alerter *Alerter) Send(severity string, msg MessageWebhook) bool {
+	ok := true
+	for _, sink := range alerter.Sinks {
+		if !sink.Wants(severity) {
+			continue
+		}
+		var err error
+		delay := alerter.RetryDelay
+		for attempt := 0; attempt <= alerter.Retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			if err = sink.send(severity, msg); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			println("Couldn't send alert '" + msg.Heading + "' to " + sink.Type + "-sink after " + strconv.Itoa(alerter.Retries+1) + " attempts: " + err.Error())
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Queue sends msg like Send, but in the background, so a sink which is down doesn't hold up the caller while it is retried.
+// The alert is dropped, and false returned, if QueueSize alerts are waiting already.
+func (alerter *Alerter) Queue(severity string, msg MessageWebhook) bool {
+	alerter.start.Do(func() {
+		size := alerter.QueueSize
+		if size < 1 {
+			size = 100
+		}
+		alerter.queue = make(chan queuedAlert, size)
+		go alerter.run()
+	})
+	alerter.pending.Add(1)
+	select {
+	case alerter.queue <- queuedAlert{severity, msg}:
+		return true
+	default:
+		alerter.pending.Done()
+		println("Dropped alert '" + msg.Heading + "', since " + strconv.Itoa(cap(alerter.queue)) + " alerts are waiting to be sent already")
+		return false
+	}
+}
+
+// run sends the queued alerts one at a time.
+func (alerter *Alerter) run() {
+	for alert := range alerter.queue {
+		alerter.Send(alert.severity, alert.msg)
+		alerter.pending.Done()
+	}
+}
+
+// Wait waits until every queued alert is sent, or has failed every retry.
+func (alerter *Alerter) Wait() {
+	alerter.pending.Wait()
+}
+
+func (sink AlertSink) send(severity string, msg MessageWebhook) error {
+	var body []byte
+	var err error
+	switch sink.Type {
+	case AlertSinkLog:
+		println("[" + severity + "] " + msg.Heading + ": " + msg.Message + " (" + msg.FromService + ", " + msg.DateTime + ")")
+		return nil
+	case AlertSinkEmail:
+		if Mail == nil {
+			return errors.New("Email is not configured on this server (SMTP_HOST is not set)")
+		}
+		text := msg.Message + "\r\n\r\n" + msg.FromService + ", " + msg.DateTime + "\r\n"
+		return Mail.Send([]string{sink.Email}, "["+severity+"] "+msg.Heading, text, "<html><body><p>"+htmltemplate.HTMLEscapeString(msg.Message)+"</p><p>"+htmltemplate.HTMLEscapeString(msg.FromService+", "+msg.DateTime)+"</p></body></html>")
+	case AlertSinkSlack:
+		body, err = buildChatText(WebhookKindSlack, "*["+severity+"] "+msg.Heading+"*\n"+msg.Message+"\n_"+msg.FromService+", "+msg.DateTime+"_")
+	default:
+		body, err = json.Marshal(&AlertPayload{msg, severity})
+	}
+	if err != nil {
+		return err
+	}
+	response, err := Delivery.Post(sink.URL, "application/json", body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("The sink answered with statuscode " + strconv.Itoa(response.StatusCode))
+	}
+	return nil
+}
+
+// SendAlert sends msg with severity to the operator-sinks in Alerts.
+func SendAlert(severity string, msg MessageWebhook) bool {
+	return Alerts.Send(severity, msg)
+}
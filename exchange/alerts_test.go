@@ -0,0 +1,101 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "os"
+import "strings"
+import "sync"
+import "time"
+
+func Test_Alerter_Send(t *testing.T) {
+	attempts := 0
+	var received []AlertPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // The first attempt fails, and is retried
+			return
+		}
+		payload := AlertPayload{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload)
+	}))
+	defer receiver.Close()
+	Delivery = setupTestDelivery(DefaultDeliveryConfig())
+
+	alerter := &Alerter{Sinks: []AlertSink{{Type: AlertSinkWebhook, URL: receiver.URL, MinSeverity: SeverityWarning}, {Type: AlertSinkLog}}, Retries: 2}
+	msg := MessageWebhook{Heading: "Failed to send webhooks!", Message: "2 failed", FromService: "test"}
+	if !alerter.Send(SeverityInfo, msg) || len(received) != 0 {
+		t.Error("Expected an info-alert to only be logged, but the webhook-sink got ", received)
+	}
+	if !alerter.Send(SeverityError, msg) {
+		t.Error("Expected the error-alert to be sent after a retry")
+	}
+	if attempts != 2 || len(received) != 1 || received[0].Severity != SeverityError || received[0].Heading != msg.Heading {
+		t.Error("Expected the webhook-sink to get the error-alert on the second attempt, got ", attempts, " attempts and ", received)
+	}
+
+	alerter.Sinks[0].URL = receiver.URL + "/gone"
+	receiver.Config.Handler = http.NotFoundHandler()
+	attempts = 0
+	if alerter.Send(SeverityError, msg) {
+		t.Error("Expected Send to return false when a sink fails every attempt")
+	}
+}
+
+func Test_Alerter_Queue(t *testing.T) {
+	release := make(chan bool)
+	var mutex sync.Mutex
+	received := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mutex.Lock()
+		received++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable) // Retried, also in the background
+	}))
+	defer receiver.Close()
+	Delivery = setupTestDelivery(DefaultDeliveryConfig())
+
+	alerter := &Alerter{Sinks: []AlertSink{{Type: AlertSinkWebhook, URL: receiver.URL}}, Retries: 1, RetryDelay: time.Millisecond, QueueSize: 1}
+	msg := MessageWebhook{Heading: "The database failed!", Message: "no reachable servers", FromService: "test"}
+	start := time.Now()
+	queued := 0
+	for i := 0; i < 3; i++ {
+		if alerter.Queue(SeverityError, msg) {
+			queued++
+		}
+	}
+	if time.Since(start) > time.Second || queued < 1 || queued > 2 { // One may be taken by the sender before the next is queued
+		t.Error("Expected Queue to return at once, and drop the alerts past the queue-size, but queued ", queued, " in ", time.Since(start))
+	}
+	close(release)
+	alerter.Wait()
+	if received != queued*2 {
+		t.Error("Expected every queued alert to be tried twice, got ", received, " attempts for ", queued)
+	}
+}
+
+func Test_AlertSinksFromEnv(t *testing.T) {
+	defer os.Unsetenv("ALERT_SINKS")
+	os.Unsetenv("ALERT_SINKS")
+	sinks, err := AlertSinksFromEnv()
+	if err != nil || len(sinks) != 1 || sinks[0].Type != AlertSinkLog {
+		t.Error("Expected only a log-sink without ALERT_SINKS, got ", sinks, err)
+	}
+
+	os.Setenv("ALERT_SINKS", `[{"type": "slack", "url": "https://hooks.slack.com/services/T/B/X", "minSeverity": "error"}, {"type": "email", "email": "ops@example.com"}]`)
+	sinks, err = AlertSinksFromEnv()
+	if err != nil || len(sinks) != 2 || sinks[0].Wants(SeverityWarning) || !sinks[1].Wants(SeverityInfo) {
+		t.Error("Expected a slack-sink for errors and an email-sink for everything, got ", sinks, err)
+	}
+
+	for _, invalid := range []string{`[{"type": "zapier"}]`, `[{"type": "webhook"}]`, `[{"type": "slack", "url": "https://example.com"}]`, `[{"type": "log", "minSeverity": "loud"}]`, `not json`} {
+		os.Setenv("ALERT_SINKS", invalid)
+		if _, err = AlertSinksFromEnv(); err == nil || !strings.HasPrefix(err.Error(), "ALERT_SINKS") {
+			t.Error("Expected an error for ALERT_SINKS " + invalid)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package exchange
+
+import "errors"
+import "net/http"
+import "strings"
+import "sync"
+import "time"
+
+// RevisionStorage is implemented by storages which can cheaply tell if the stored currencies have changed, also when they were
+// stored by another process (the updater). The revision is opaque, and only compared with the one from the last call.
+type RevisionStorage interface {
+	CurrencyRevision() (string, int, error)
+}
+
+// CacheStats - This is the struct which tells how well the cache works. A hit is a read answered from memory,
+// a miss is one that had to go to the storage.
+type CacheStats struct {
+	Hits          int `json:"hits"`
+	Misses        int `json:"misses"`
+	Invalidations int `json:"invalidations"`
+}
+
+type cachedCurrency struct {
+	currency  Currency
+	expiresAt time.Time
+}
+
+type cachedAverage struct {
+	average   float32
+	expiresAt time.Time
+}
+
+// CachedStorage is a Storage which wraps another one, and keeps the latest snapshot per base-currency and the averages in memory
+// for TTL, so most reads of the rates never reach the database. Everything else (webhooks, older snapshots) goes straight to the
+// wrapped storage. Storing or resetting currencies through it invalidates what it has of them. If the wrapped storage is a
+// RevisionStorage, the revision is checked at most every CheckInterval, and everything is invalidated when it has changed,
+// so snapshots stored by the updater are picked up without waiting for TTL. Errors are never cached.
+type CachedStorage struct {
+	Storage
+	TTL           time.Duration
+	CheckInterval time.Duration
+
+	now        func() time.Time
+	mutex      sync.Mutex
+	latest     map[string]cachedCurrency
+	averages   map[string]cachedAverage
+	revision   string
+	checkedAt  time.Time
+	generation int // Counts the invalidations, so a read which raced with one isn't cached
+	stats      CacheStats
+}
+
+// NewCachedStorage wraps storage in a cache where the rates are kept for ttl, and the revision is checked every 10 seconds.
+func NewCachedStorage(storage Storage, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		Storage:       storage,
+		TTL:           ttl,
+		CheckInterval: 10 * time.Second,
+		now:           time.Now,
+		latest:        map[string]cachedCurrency{},
+		averages:      map[string]cachedAverage{},
+	}
+}
+
+// Stats returns the hits, misses and invalidations since the cache was made.
+func (cache *CachedStorage) Stats() CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.stats
+}
+
+// Invalidate forgets everything in the cache.
+func (cache *CachedStorage) Invalidate() {
+	cache.mutex.Lock()
+	cache.invalidate()
+	cache.mutex.Unlock()
+}
+
+func (cache *CachedStorage) invalidate() {
+	cache.latest = map[string]cachedCurrency{}
+	cache.averages = map[string]cachedAverage{}
+	cache.generation++
+	cache.stats.Invalidations++
+}
+
+// checkRevision invalidates the cache if the revision in the storage has changed since it was last checked, at most every CheckInterval.
+// The revision is read without the mutex, so the reads answered from memory never wait for the database, and only the read which
+// is due checks it. If the revision can't be read, the cache is invalidated too, so a broken check never makes us serve old rates
+// for longer than CheckInterval.
+func (cache *CachedStorage) checkRevision() {
+	revisionStorage, ok := cache.Storage.(RevisionStorage)
+	if !ok {
+		return
+	}
+	cache.mutex.Lock()
+	now := cache.now()
+	due := now.Sub(cache.checkedAt) >= cache.CheckInterval
+	if due {
+		cache.checkedAt = now // Set before the revision is read, so the reads meanwhile don't check it too
+	}
+	cache.mutex.Unlock()
+	if !due {
+		return
+	}
+
+	revision, _, err := revisionStorage.CurrencyRevision()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if err != nil || revision != cache.revision {
+		cache.revision = revision
+		cache.invalidate()
+	}
+}
+
+// lookupLatest returns the cached latest snapshot of baseCurrency, and counts the hit or miss.
+// It also returns the generation, so the snapshot read on a miss is only cached if nothing was invalidated meanwhile.
+func (cache *CachedStorage) lookupLatest(baseCurrency string) (Currency, int, bool) {
+	cache.checkRevision()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := cache.now()
+	cached, ok := cache.latest[baseCurrency]
+	if !ok || !now.Before(cached.expiresAt) {
+		cache.stats.Misses++
+		return Currency{}, cache.generation, false
+	}
+	cache.stats.Hits++
+	return cached.currency, cache.generation, true
+}
+
+// GetLatestCurrency returns the latest snapshot of baseCurrency, from memory if it is there. The rates-map is shared, and must not be changed.
+func (cache *CachedStorage) GetLatestCurrency(baseCurrency string) (Currency, int, error) {
+	currency, generation, ok := cache.lookupLatest(baseCurrency)
+	if ok {
+		return currency, http.StatusOK, nil
+	}
+	currency, statusCode, err := cache.Storage.GetLatestCurrency(baseCurrency)
+	if err != nil {
+		return currency, statusCode, err
+	}
+	cache.mutex.Lock()
+	if generation == cache.generation {
+		cache.latest[baseCurrency] = cachedCurrency{currency, cache.now().Add(cache.TTL)}
+	}
+	cache.mutex.Unlock()
+	return currency, statusCode, nil
+}
+
+// GetLatest returns the latest rate from baseCurrency to targetCurrency, and its date, from the cached snapshot.
+func (cache *CachedStorage) GetLatest(baseCurrency string, targetCurrency string) (float32, string, int, error) {
+	currency, statusCode, err := cache.GetLatestCurrency(baseCurrency)
+	if err != nil {
+		return -1, "", statusCode, err
+	}
+	rate, ok := currency.Rates[targetCurrency]
+	if !ok {
+		return -1, "", http.StatusBadRequest, errors.New("TargetCurrency not an accepted rate")
+	}
+	return rate, currency.Date, http.StatusOK, nil
+}
+
+// GetAverage returns the average from baseCurrency to targetCurrency, from memory if it is there.
+func (cache *CachedStorage) GetAverage(baseCurrency string, targetCurrency string) (float32, int, error) {
+	key := baseCurrency + " " + targetCurrency
+	cache.checkRevision()
+	cache.mutex.Lock()
+	now := cache.now()
+	cached, ok := cache.averages[key]
+	if ok && now.Before(cached.expiresAt) {
+		cache.stats.Hits++
+		cache.mutex.Unlock()
+		return cached.average, http.StatusOK, nil
+	}
+	cache.stats.Misses++
+	generation := cache.generation
+	cache.mutex.Unlock()
+
+	average, statusCode, err := cache.Storage.GetAverage(baseCurrency, targetCurrency)
+	if err != nil {
+		return average, statusCode, err
+	}
+	cache.mutex.Lock()
+	if generation == cache.generation {
+		cache.averages[key] = cachedAverage{average, cache.now().Add(cache.TTL)}
+	}
+	cache.mutex.Unlock()
+	return average, statusCode, nil
+}
+
+// RegisterCurrencyToDatabase stores currency, and forgets the latest snapshot and the averages of its base-currency.
+func (cache *CachedStorage) RegisterCurrencyToDatabase(currency Currency) (int, error) {
+	statusCode, err := cache.Storage.RegisterCurrencyToDatabase(currency)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.latest, currency.Base)
+	for key := range cache.averages {
+		if strings.HasPrefix(key, currency.Base+" ") {
+			delete(cache.averages, key)
+		}
+	}
+	cache.generation++
+	cache.stats.Invalidations++
+	return statusCode, err
+}
+
+// ResetCurrency removes every currency from the storage, and forgets everything in the cache.
+func (cache *CachedStorage) ResetCurrency() bool {
+	ok := cache.Storage.ResetCurrency()
+	cache.Invalidate()
+	return ok
+}
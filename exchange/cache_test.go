@@ -0,0 +1,162 @@
+package exchange
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "sync"
+import "time"
+
+// countingStorage counts the reads of the rates which reach the storage.
+type countingStorage struct {
+	*memoryStorage
+	reads int
+}
+
+func (db *countingStorage) GetLatestCurrency(baseCurrency string) (Currency, int, error) {
+	db.reads++
+	return db.memoryStorage.GetLatestCurrency(baseCurrency)
+}
+
+func (db *countingStorage) GetAverage(baseCurrency string, targetCurrency string) (float32, int, error) {
+	db.reads++
+	return db.memoryStorage.GetAverage(baseCurrency, targetCurrency)
+}
+
+func setupCachedStorage(backend Storage, now *time.Time) *CachedStorage {
+	cache := NewCachedStorage(backend, time.Hour)
+	cache.now = func() time.Time { return *now }
+	return cache
+}
+
+func Test_CachedStorage_TTLAndInvalidation(t *testing.T) {
+	backend := &countingStorage{memoryStorage: setupValidationDatabase()}
+	backend.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-17", map[string]float32{"NOK": 9.5}})
+	backend.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-16", map[string]float32{"NOK": 9.4}})
+	now := time.Date(2017, 11, 20, 18, 0, 0, 0, time.UTC)
+	cache := setupCachedStorage(backend, &now)
+
+	for i := 0; i < 3; i++ {
+		if rate, date, _, err := cache.GetLatest("EUR", "NOK"); err != nil || date != "2017-11-20" || rate == 0 {
+			t.Error("Expected the latest rate, but got ", rate, " from "+date+": ", err)
+		}
+		cache.GetLatestCurrency("EUR")
+		cache.GetAverage("EUR", "NOK")
+	}
+	if stats := cache.Stats(); backend.reads != 2 || stats.Hits != 7 || stats.Misses != 2 {
+		t.Error("Expected the storage to be read once for latest and once for the average, but it was read ", backend.reads, " times, with stats ", stats)
+	}
+	if _, _, _, err := cache.GetLatest("EUR", "XYZ"); err == nil {
+		t.Error("Expected an error for a target which isn't in the cached snapshot")
+	}
+
+	now = now.Add(time.Hour)
+	cache.GetLatestCurrency("EUR")
+	cache.GetAverage("EUR", "NOK")
+	if backend.reads != 4 {
+		t.Error("Expected the storage to be read again after TTL, but it was read ", backend.reads, " times")
+	}
+
+	cache.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.9}})
+	if _, date, _, _ := cache.GetLatest("EUR", "NOK"); date != "2017-11-21" {
+		t.Error("Expected the new snapshot right after it is stored, but got " + date)
+	}
+	if average, _, _ := cache.GetAverage("EUR", "NOK"); backend.reads != 6 || average < 9.5 {
+		t.Error("Expected the average to be computed again after a snapshot is stored, but got ", average)
+	}
+
+	cache.ResetCurrency()
+	if _, statusCode, err := cache.GetLatestCurrency("EUR"); err == nil || statusCode == http.StatusOK {
+		t.Error("Expected an error after the currencies are reset, but got ", statusCode)
+	}
+	cache.GetLatestCurrency("EUR")
+	if backend.reads != 8 {
+		t.Error("Expected errors not to be cached, but the storage was read ", backend.reads, " times")
+	}
+}
+
+func Test_CachedStorage_OtherProcess(t *testing.T) {
+	backend := setupValidationDatabase() // Shared like a database
+	now := time.Date(2017, 11, 20, 18, 0, 0, 0, time.UTC)
+	api := setupCachedStorage(backend, &now)
+	updater := setupCachedStorage(backend, &now)
+
+	api.GetLatestCurrency("EUR")
+	updater.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.9}})
+	if currency, _, _ := api.GetLatestCurrency("EUR"); currency.Date != "2017-11-20" {
+		t.Error("Expected the old snapshot until the revision is checked, but got " + currency.Date)
+	}
+	now = now.Add(api.CheckInterval)
+	if currency, _, _ := api.GetLatestCurrency("EUR"); currency.Date != "2017-11-21" {
+		t.Error("Expected the snapshot stored by the other process after the revision is checked, but got " + currency.Date)
+	}
+
+	DB = api
+	w := httptest.NewRecorder()
+	NewAPIRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v1/latest?base=EUR&target=NOK", nil))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2017-11-21-json"` {
+		t.Error("Expected the handlers to work through the cache, but got ", w.Code, ": "+w.Body.String())
+	}
+}
+
+// slowRevisionStorage is a storage where reading the revision waits until release is closed, like a slow database.
+type slowRevisionStorage struct {
+	*memoryStorage
+	reading chan bool
+	release chan bool
+}
+
+func (db *slowRevisionStorage) CurrencyRevision() (string, int, error) {
+	db.reading <- true
+	<-db.release
+	return db.memoryStorage.CurrencyRevision()
+}
+
+func Test_CachedStorage_RevisionOutsideLock(t *testing.T) {
+	backend := &slowRevisionStorage{memoryStorage: setupValidationDatabase(), reading: make(chan bool, 1), release: make(chan bool)}
+	now := time.Date(2017, 11, 20, 18, 0, 0, 0, time.UTC)
+	cache := NewCachedStorage(backend, time.Hour)
+	var mutex sync.Mutex
+	cache.now = func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	close(backend.release)
+	cache.GetLatestCurrency("EUR") // Reads the first revision, and caches the snapshot
+	<-backend.reading
+	backend.release = make(chan bool)
+
+	mutex.Lock()
+	now = now.Add(cache.CheckInterval)
+	mutex.Unlock()
+	done := make(chan bool)
+	go func() {
+		cache.GetLatestCurrency("EUR") // Checks the revision, which waits
+		done <- true
+	}()
+	<-backend.reading
+	answered := make(chan bool)
+	go func() {
+		cache.GetLatestCurrency("EUR")
+		cache.GetAverage("EUR", "NOK")
+		answered <- true
+	}()
+	select {
+	case <-answered:
+	case <-time.After(time.Second):
+		t.Error("Expected the cache to answer while the revision is read")
+	}
+	close(backend.release)
+	<-done
+}
+
+func Test_Config_APIStorage(t *testing.T) {
+	config := DefaultConfig()
+	if cache, ok := config.APIStorage().(*CachedStorage); !ok || cache.TTL != time.Hour || cache.CheckInterval != 10*time.Second {
+		t.Error("Expected the MongoDB to be cached by default")
+	}
+	config.Cache.TTL.Duration = 0
+	if _, ok := config.APIStorage().(*MongoDB); !ok {
+		t.Error("Expected no cache when cache.ttl is 0")
+	}
+}
@@ -0,0 +1,186 @@
+package exchange
+
+import "encoding/json"
+import "net/url"
+import "strconv"
+import "strings"
+
+// The kinds of webhooks we can send. WebhookKindJSON (or no kind) sends SendWebhook or the payload-template.
+const (
+	WebhookKindJSON    = "json"
+	WebhookKindSlack   = "slack"
+	WebhookKindDiscord = "discord"
+)
+
+// The colors used in chat-messages, green when the rate goes up and red when it goes down.
+const (
+	colorUp      = "#2eb886"
+	colorDown    = "#d50200"
+	colorNeutral = "#439fe0"
+)
+
+// SlackMessage - This is the struct which is sent to a Slack incoming webhook.
+type SlackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []SlackAttachment `json:"attachments"`
+}
+
+// SlackAttachment - This is the struct which holds a formatted part of a Slack message.
+type SlackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color"`
+	Title    string       `json:"title"`
+	Fields   []SlackField `json:"fields"`
+	Footer   string       `json:"footer"`
+}
+
+// SlackField - This is the struct which holds one field in a Slack attachment.
+type SlackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// DiscordMessage - This is the struct which is sent to a Discord webhook.
+type DiscordMessage struct {
+	Content string         `json:"content"`
+	Embeds  []DiscordEmbed `json:"embeds"`
+}
+
+// DiscordEmbed - This is the struct which holds a formatted part of a Discord message.
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Color       int                 `json:"color"`
+	Fields      []DiscordEmbedField `json:"fields"`
+	Footer      struct {
+		Text string `json:"text"`
+	} `json:"footer"`
+}
+
+// DiscordEmbedField - This is the struct which holds one field in a Discord embed.
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// IsChatKind tells if the kind is a chat-tool, which gets a formatted message instead of json we decide the shape of.
+func IsChatKind(kind string) bool {
+	return kind == WebhookKindSlack || kind == WebhookKindDiscord
+}
+
+// checkChatURL returns what is wrong if rawURL isn't an incoming webhook for the chat-tool kind, or an empty string.
+// Chat-tools can't echo a verification-challenge back, so we only allow their own hosts.
+func checkChatURL(kind string, rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "is not a valid url"
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	if parsedURL.Scheme != "https" {
+		return "must use https for kind " + kind
+	}
+	if kind == WebhookKindSlack && host != "hooks.slack.com" {
+		return "must be a Slack incoming webhook (https://hooks.slack.com/...) for kind slack"
+	}
+	if kind == WebhookKindDiscord && ((host != "discord.com" && host != "discordapp.com") || !strings.HasPrefix(parsedURL.Path, "/api/webhooks/")) {
+		return "must be a Discord webhook (https://discord.com/api/webhooks/...) for kind discord"
+	}
+	return ""
+}
+
+// trendArrow returns an arrow showing which way the rate has moved since the previous snapshot.
+func trendArrow(data PayloadData) string {
+	if !data.HasPrevious || data.Change == 0 {
+		return "→"
+	}
+	if data.Change > 0 {
+		return "↑"
+	}
+	return "↓"
+}
+
+func trendColor(data PayloadData) string {
+	if !data.HasPrevious || data.Change == 0 {
+		return colorNeutral
+	}
+	if data.Change > 0 {
+		return colorUp
+	}
+	return colorDown
+}
+
+// triggerBand describes the trigger-range, where an unset (0) min or max is open.
+func triggerBand(data PayloadData) string {
+	if data.MinTriggerValue != 0 && data.MaxTriggerValue != 0 {
+		return FloatToString(data.MinTriggerValue) + " – " + FloatToString(data.MaxTriggerValue)
+	}
+	if data.MinTriggerValue != 0 {
+		return "≥ " + FloatToString(data.MinTriggerValue)
+	}
+	return "≤ " + FloatToString(data.MaxTriggerValue)
+}
+
+func changeText(data PayloadData) string {
+	if !data.HasPrevious {
+		return "No previous rate"
+	}
+	sign := ""
+	if data.Change >= 0 {
+		sign = "+"
+	}
+	return sign + FloatToString(data.Change) + " (" + sign + strconv.FormatFloat(float64(data.ChangePercent), 'f', 2, 32) + "%)"
+}
+
+// chatSummary is the one-line text used as title and notification-text.
+func chatSummary(data PayloadData) string {
+	return data.Pair + " is " + FloatToString(data.CurrentRate) + " " + trendArrow(data)
+}
+
+// BuildSlackMessage formats a notification as a Slack message with an attachment.
+func BuildSlackMessage(data PayloadData) ([]byte, error) {
+	message := SlackMessage{Text: chatSummary(data), Attachments: []SlackAttachment{slackAttachment(data)}}
+	return json.Marshal(&message)
+}
+
+func slackAttachment(data PayloadData) SlackAttachment {
+	return SlackAttachment{
+		Fallback: chatSummary(data) + " (trigger-range " + triggerBand(data) + ")",
+		Color:    trendColor(data),
+		Title:    data.Pair,
+		Fields: []SlackField{
+			{"Rate", FloatToString(data.CurrentRate) + " " + trendArrow(data), true},
+			{"Trigger-range", triggerBand(data), true},
+			{"Change", changeText(data), true},
+			{"Date", data.Date, true},
+		},
+		Footer: "Currency exchange webhook",
+	}
+}
+
+// BuildDiscordMessage formats a notification as a Discord message with an embed.
+func BuildDiscordMessage(data PayloadData) ([]byte, error) {
+	return json.Marshal(&DiscordMessage{Embeds: []DiscordEmbed{discordEmbed(data)}})
+}
+
+func discordEmbed(data PayloadData) DiscordEmbed {
+	color, _ := strconv.ParseInt(strings.TrimPrefix(trendColor(data), "#"), 16, 32)
+	embed := DiscordEmbed{Title: chatSummary(data), Description: "The rate is inside your trigger-range " + triggerBand(data) + ".", Color: int(color)}
+	embed.Fields = []DiscordEmbedField{
+		{"Rate", FloatToString(data.CurrentRate) + " " + trendArrow(data), true},
+		{"Trigger-range", triggerBand(data), true},
+		{"Change", changeText(data), true},
+		{"Date", data.Date, true},
+	}
+	embed.Footer.Text = "Currency exchange webhook"
+	return embed
+}
+
+// buildChatText formats a plain text message for a chat-tool, used when verifying the webhook.
+func buildChatText(kind string, text string) ([]byte, error) {
+	if kind == WebhookKindDiscord {
+		return json.Marshal(&DiscordMessage{Content: text, Embeds: []DiscordEmbed{}})
+	}
+	return json.Marshal(&SlackMessage{Text: text, Attachments: []SlackAttachment{}})
+}
@@ -0,0 +1,60 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+
+func Test_BuildChatMessages(t *testing.T) {
+	webhook := Webhook{BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, MaxTriggerValue: 10}
+	notification := Notification{Webhook: webhook, CurrentRate: 9.5, PreviousRate: 9.6, Date: "2017-11-20"}
+
+	notification.Webhook.Kind = WebhookKindSlack
+	payload, _, err := BuildPayload(notification)
+	slackMessage := SlackMessage{}
+	if err != nil || json.Unmarshal(payload, &slackMessage) != nil {
+		t.Error("Couldn't build Slack message: ", err)
+		return
+	}
+	if slackMessage.Text != "EUR/NOK is 9.5 ↓" || len(slackMessage.Attachments) != 1 || slackMessage.Attachments[0].Color != colorDown {
+		t.Error("Unexpected Slack message: " + string(payload))
+	}
+	if slackMessage.Attachments[0].Fields[1].Value != "9 – 10" {
+		t.Error("Expected the trigger-range to be 9 – 10, got " + slackMessage.Attachments[0].Fields[1].Value)
+	}
+
+	notification.Webhook.Kind = WebhookKindDiscord
+	notification.Webhook.MaxTriggerValue = 0
+	notification.PreviousRate = 9.4
+	payload, _, err = BuildPayload(notification)
+	discordMessage := DiscordMessage{}
+	if err != nil || json.Unmarshal(payload, &discordMessage) != nil {
+		t.Error("Couldn't build Discord message: ", err)
+		return
+	}
+	embed := discordMessage.Embeds[0]
+	if embed.Title != "EUR/NOK is 9.5 ↑" || embed.Color != 0x2eb886 || embed.Fields[1].Value != "≥ 9" {
+		t.Error("Unexpected Discord message: " + string(payload))
+	}
+}
+
+func Test_ValidateChatWebhook(t *testing.T) {
+	db := setupValidationDatabase()
+	tests := []struct {
+		webhook Webhook
+		field   string // The field that should have an error, empty if webhook is valid
+	}{
+		{Webhook{Kind: WebhookKindSlack, WebhookURL: "https://hooks.slack.com/services/T0/B0/X"}, ""},
+		{Webhook{Kind: WebhookKindDiscord, WebhookURL: "https://discord.com/api/webhooks/1/abc"}, ""},
+		{Webhook{Kind: WebhookKindSlack, WebhookURL: "https://example.com/services/T0/B0/X"}, "webhookurl"},
+		{Webhook{Kind: WebhookKindDiscord, WebhookURL: "https://discord.com/channels/1"}, "webhookurl"},
+		{Webhook{Kind: WebhookKindSlack, WebhookURL: "http://hooks.slack.com/services/T0/B0/X"}, "webhookurl"},
+		{Webhook{Kind: WebhookKindSlack, WebhookURL: "https://hooks.slack.com/services/T0/B0/X", PayloadTemplate: "{{.Pair}}"}, "kind"},
+		{Webhook{Kind: "teams", WebhookURL: "https://example.com/"}, "kind"},
+	}
+	for _, test := range tests {
+		test.webhook.BaseCurrency, test.webhook.TargetCurrency, test.webhook.MinTriggerValue = "EUR", "NOK", 1
+		errs, _, _ := ValidateWebhook(test.webhook, db)
+		if (test.field == "" && len(errs) != 0) || (test.field != "" && !hasFieldError(errs, test.field)) {
+			t.Error("Unexpected validation of "+test.webhook.Kind+" webhook "+test.webhook.WebhookURL+": ", errs)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package exchange
+
+import "bytes"
+import "context"
+import "errors"
+import "io"
+import "io/ioutil"
+import "net"
+import "net/http"
+import "net/url"
+import "os"
+import "strconv"
+import "time"
+
+// DeliveryConfig - Settings for the http-client used when sending webhooks, the delivery-section of Config.
+type DeliveryConfig struct {
+	ConnectTimeout         Duration `json:"connectTimeout" yaml:"connectTimeout"` // Time to resolve and connect to the receiver
+	Timeout                Duration `json:"timeout" yaml:"timeout"`               // Time for the whole request, including reading the response
+	ProxyURL               string   `json:"proxyURL" yaml:"proxyURL"`             // Send through this proxy if set, the password is redacted when logged
+	UserAgent              string   `json:"userAgent" yaml:"userAgent"`
+	MaxResponseBytes       int64    `json:"maxResponseBytes" yaml:"maxResponseBytes"` // We never read more than this from a response
+	MaxIdleConnsPerHost    int      `json:"maxIdleConnsPerHost" yaml:"maxIdleConnsPerHost"`
+	MaxConsecutiveFailures int      `json:"maxConsecutiveFailures" yaml:"maxConsecutiveFailures"` // Deliveries in a row which can fail before the webhook is disabled
+}
+
+// DeliveryResponse - This is the struct which holds what we got back from a receiver.
+type DeliveryResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte // At most MaxResponseBytes of the response-body
+	Latency     time.Duration
+}
+
+// DeliveryClient is a http-client shared by all webhook-deliveries, so connections are reused.
+type DeliveryClient struct {
+	Config DeliveryConfig
+	policy *EgressPolicy
+	client *http.Client
+}
+
+// Delivery is the client used when sending webhooks.
+var Delivery = NewDeliveryClient(DefaultDeliveryConfig(), Egress)
+
+// DefaultDeliveryConfig returns the settings used if nothing else is configured.
+func DefaultDeliveryConfig() DeliveryConfig {
+	return DeliveryConfig{
+		ConnectTimeout:         Duration{5 * time.Second},
+		Timeout:                Duration{15 * time.Second},
+		UserAgent:              "CloudTecnologies-Exchange-Webhook/1.0",
+		MaxResponseBytes:       4096,
+		MaxIdleConnsPerHost:    10,
+		MaxConsecutiveFailures: 5,
+	}
+}
+
+// DeliveryConfigFromEnv overrides the settings in config with WEBHOOK_CONNECT_TIMEOUT, WEBHOOK_TIMEOUT (durations like "5s"),
+// WEBHOOK_PROXY, WEBHOOK_USER_AGENT, WEBHOOK_MAX_RESPONSE_BYTES and WEBHOOK_MAX_CONSECUTIVE_FAILURES, where they are set.
+func DeliveryConfigFromEnv(config DeliveryConfig) (DeliveryConfig, error) {
+	var err error
+	for variable, field := range map[string]*Duration{"WEBHOOK_CONNECT_TIMEOUT": &config.ConnectTimeout, "WEBHOOK_TIMEOUT": &config.Timeout} {
+		if value := os.Getenv(variable); value != "" {
+			if err = field.parse(value); err != nil {
+				return config, errors.New(variable + ": " + err.Error())
+			}
+		}
+	}
+	if value := os.Getenv("WEBHOOK_PROXY"); value != "" {
+		config.ProxyURL = value
+	}
+	if value := os.Getenv("WEBHOOK_USER_AGENT"); value != "" {
+		config.UserAgent = value
+	}
+	if value := os.Getenv("WEBHOOK_MAX_RESPONSE_BYTES"); value != "" {
+		if config.MaxResponseBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return config, errors.New("WEBHOOK_MAX_RESPONSE_BYTES: " + err.Error())
+		}
+	}
+	if value := os.Getenv("WEBHOOK_MAX_CONSECUTIVE_FAILURES"); value != "" {
+		if config.MaxConsecutiveFailures, err = strconv.Atoi(value); err != nil {
+			return config, errors.New("WEBHOOK_MAX_CONSECUTIVE_FAILURES: " + err.Error())
+		}
+	}
+	return config, nil
+}
+
+func (config DeliveryConfig) validate() error {
+	if config.ConnectTimeout.Duration <= 0 || config.Timeout.Duration <= 0 {
+		return errors.New("connectTimeout and timeout must be positive")
+	}
+	if proxyURL, err := url.Parse(config.ProxyURL); config.ProxyURL != "" && (err != nil || proxyURL.Host == "") {
+		return errors.New("proxyURL must be a url like http://proxy.example:3128")
+	}
+	if config.MaxResponseBytes <= 0 || config.MaxIdleConnsPerHost <= 0 || config.MaxConsecutiveFailures <= 0 {
+		return errors.New("maxResponseBytes, maxIdleConnsPerHost and maxConsecutiveFailures must be positive")
+	}
+	return nil
+}
+
+// NewDeliveryClient makes a client which only connects to addresses allowed by policy.
+// If a proxy is configured, the proxy itself is not checked (it is set by us), but every url is checked before it is sent through it,
+// also the ones we are redirected to. The proxy resolves the host again when it connects, so a host which changes its address
+// between our check and the proxy's lookup (DNS-rebinding) is not stopped by us, and must be stopped by the proxy.
+func NewDeliveryClient(config DeliveryConfig, policy *EgressPolicy) *DeliveryClient {
+	deliveryClient := &DeliveryClient{Config: config, policy: policy}
+	transport := &http.Transport{
+		DialContext:           deliveryClient.dialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   config.ConnectTimeout.Duration,
+		ResponseHeaderTimeout: config.Timeout.Duration,
+	}
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err == nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	deliveryClient.client = &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout.Duration,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("Stopped after 5 redirects")
+			}
+			return deliveryClient.checkProxiedURL(req.URL.String())
+		},
+	}
+	return deliveryClient
+}
+
+func (deliveryClient *DeliveryClient) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryClient.Config.ConnectTimeout.Duration)
+	defer cancel()
+	if deliveryClient.Config.ProxyURL != "" { // With a proxy we only connect to the proxy
+		dialer := net.Dialer{}
+		return dialer.DialContext(ctx, network, address)
+	}
+	return deliveryClient.policy.DialContext(ctx, network, address)
+}
+
+// checkProxiedURL checks the url up front when we use a proxy, since we then never dial the receiver ourselves.
+func (deliveryClient *DeliveryClient) checkProxiedURL(rawURL string) error {
+	if deliveryClient.Config.ProxyURL == "" {
+		return nil // Checked when dialing
+	}
+	return deliveryClient.policy.CheckURL(rawURL)
+}
+
+// Post sends body to rawURL, and returns the statuscode, the start of the response-body and how long it took.
+func (deliveryClient *DeliveryClient) Post(rawURL string, contentType string, body []byte) (DeliveryResponse, error) {
+	response := DeliveryResponse{}
+	req, err := http.NewRequest("POST", rawURL, bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", deliveryClient.Config.UserAgent)
+	if err := deliveryClient.checkProxiedURL(rawURL); err != nil {
+		return response, err
+	}
+
+	start := time.Now()
+	resp, err := deliveryClient.client.Do(req)
+	if err != nil {
+		response.Latency = time.Since(start)
+		return response, err
+	}
+	defer resp.Body.Close()
+	response.StatusCode = resp.StatusCode
+	response.ContentType = resp.Header.Get("Content-Type")
+	response.Body, err = ioutil.ReadAll(io.LimitReader(resp.Body, deliveryClient.Config.MaxResponseBytes))
+	response.Latency = time.Since(start)
+	io.CopyN(ioutil.Discard, resp.Body, 64*1024) // Read a bit more, so the connection can be reused if the response was just a little too long
+	return response, err
+}
@@ -0,0 +1,92 @@
+package exchange
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "time"
+
+func setupTestDelivery(config DeliveryConfig) *DeliveryClient {
+	return NewDeliveryClient(config, setupTestEgress([]string{"127.0.0.1"}, nil))
+}
+
+func Test_DeliveryClient_Post(t *testing.T) {
+	var userAgent, contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer ts.Close()
+
+	config := DefaultDeliveryConfig()
+	config.UserAgent = "test-agent"
+	config.MaxResponseBytes = 10
+	response, err := setupTestDelivery(config).Post(ts.URL, "application/json", []byte("{}"))
+	if err != nil {
+		t.Error("Error when posting: ", err)
+		return
+	}
+	if response.StatusCode != http.StatusAccepted {
+		t.Error("Expected statuscode ", http.StatusAccepted, ", but got ", response.StatusCode)
+	}
+	if len(response.Body) != 10 {
+		t.Error("Expected to read 10 bytes of the response, but read ", len(response.Body))
+	}
+	if userAgent != "test-agent" || contentType != "application/json" {
+		t.Error("Expected user-agent test-agent and content-type application/json, but got " + userAgent + " and " + contentType)
+	}
+}
+
+func Test_DeliveryClient_Timeout(t *testing.T) {
+	done := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done // Hang until the test is finished
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	config := DefaultDeliveryConfig()
+	config.Timeout = Duration{100 * time.Millisecond}
+	start := time.Now()
+	_, err := setupTestDelivery(config).Post(ts.URL, "application/json", []byte("{}"))
+	if err == nil {
+		t.Error("Expected a timeout from a hanging receiver")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("The timeout wasn't respected, the request took ", time.Since(start))
+	}
+}
+
+func Test_DeliveryClient_Denied(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	deliveryClient := NewDeliveryClient(DefaultDeliveryConfig(), setupTestEgress(nil, nil))
+	_, err := deliveryClient.Post(ts.URL, "application/json", []byte("{}"))
+	if err == nil {
+		t.Error("Expected posting to loopback to be denied by the default egress-policy")
+	}
+}
+
+func Test_DeliveryClient_Proxy(t *testing.T) {
+	var requested []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.String())
+		if r.URL.Host == "example.com" {
+			http.Redirect(w, r, "http://metadata.example/latest/meta-data/", http.StatusFound)
+		}
+	}))
+	defer proxy.Close()
+	config := DefaultDeliveryConfig()
+	config.ProxyURL = proxy.URL
+	deliveryClient := NewDeliveryClient(config, setupTestEgress(nil, nil))
+
+	if _, err := deliveryClient.Post("http://rebind.example/hook", "application/json", []byte("{}")); err == nil || len(requested) != 0 {
+		t.Error("Expected a denied url not to be sent through the proxy, but the proxy got ", requested)
+	}
+	if _, err := deliveryClient.Post("http://example.com/hook", "application/json", []byte("{}")); err == nil || len(requested) != 1 {
+		t.Error("Expected a redirect to a denied address not to be followed through the proxy, but the proxy got ", requested)
+	}
+}
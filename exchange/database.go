@@ -2,13 +2,12 @@ package exchange
 
 import "fmt"
 import "net/http"
-import "strings"
 import "encoding/json"
 import "gopkg.in/mgo.v2"
 import "gopkg.in/mgo.v2/bson"
 import "errors"
-import "bytes"
 import "strconv"
+import "time"
 
 // Webhook - This is the struct which will hold information about a webhook.
 type Webhook struct {
@@ -18,12 +17,33 @@ type Webhook struct {
 	MinTriggerValue float32 `json:"minTriggerValue"`
 	MaxTriggerValue float32 `json:"maxTriggerValue"`
 	ID              string  `json:"id"`
+	Status          string  `json:"status"`
+	Challenge       string  `json:"-"`                         // Sent to the webhook when verifying it, and must be echoed back
+	ExpiresAt       string  `json:"expiresAt,omitempty"`       // RFC 3339, the webhook is deleted after this if set
+	Failures        int     `json:"consecutiveFailures"`       // Failed deliveries since the last one that worked
+	PayloadTemplate string  `json:"payloadTemplate,omitempty"` // A text/template rendered with PayloadData instead of sending SendWebhook
+	ContentType     string  `json:"contentType,omitempty"`     // Content-type of the rendered payload-template
+	Kind            string  `json:"kind,omitempty"`            // WebhookKindJSON (default), WebhookKindSlack, WebhookKindDiscord or WebhookKindEmail
+	Email           string  `json:"email,omitempty"`           // Where notifications are sent for WebhookKindEmail
+	Digest          string  `json:"digest,omitempty"`          // DigestDaily or DigestWeekly to get the triggered pairs in one digest instead of one notification each
+	DigestSentAt    string  `json:"digestSentAt,omitempty"`    // The date (2006-01-02) the last digest with this webhook was sent
 }
 
 // CurrencyRequest - This is the struct which will hold information about currencies from user.
 type CurrencyRequest struct {
 	BaseCurrency   string `json:"baseCurrency"`
 	TargetCurrency string `json:"targetCurrency"`
+	AsOf           string `json:"asOf,omitempty"` // A date like 2017-11-20, to get the rate as it was then instead of the latest
+}
+
+// RateResponse - This is the struct which is sent back for latest and average. Date is the date of the rate that was used, and is not set for averages.
+// AsOf is the date that was asked for, if any, so it can be seen when an earlier business day was used.
+type RateResponse struct {
+	BaseCurrency   string  `json:"baseCurrency"`
+	TargetCurrency string  `json:"targetCurrency"`
+	Rate           float32 `json:"rate"`
+	Date           string  `json:"date,omitempty"`
+	AsOf           string  `json:"asOf,omitempty"`
 }
 
 // BotRequest - This is the struct which will hold information gotten from a bot (just useful stuff).
@@ -83,7 +103,13 @@ type Storage interface {
 	RegisterWebhookToDatabase(webhook Webhook) (string, int, error)
 	GetWebhook(id string) (Webhook, int, error)
 	DeleteWebhook(id string) (int, error)
+	UpdateWebhook(webhook Webhook) (int, error)
+	SetWebhookStatus(id string, from string, status string) (Webhook, int, error)
+	RecordWebhookDelivery(id string, failed bool, digestSentAt string, maxFailures int) (Webhook, bool, int, error)
 	GetLatest(string, string) (float32, string, int, error)
+	GetLatestCurrency(string) (Currency, int, error)
+	GetCurrencyAsOf(string, string) (Currency, int, error)
+	GetCurrencies(string, int) ([]Currency, int, error)
 	GetAverage(string, string) (float32, int, error)
 	RegisterCurrencyToDatabase(Currency) (int, error)
 	GetAllWebhooks() ([]Webhook, int, error)
@@ -173,10 +199,107 @@ func (DB *MongoDB) GetWebhook(id string) (Webhook, int, error) {
 	defer session.Close()
 
 	err = session.DB(DB.DatabaseName).C(DB.WebhookCollectionName).Find(bson.M{"id": id}).One(&webhook)
+	if err == mgo.ErrNotFound {
+		return webhook, http.StatusNotFound, errors.New("No webhook with id " + id)
+	}
+	if err != nil {
+		return webhook, 500, err
+	}
+	return webhook, http.StatusOK, nil
+}
+
+// UpdateWebhook replaces the stored webhook which has the same id as webhook.
+func (DB *MongoDB) UpdateWebhook(webhook Webhook) (int, error) {
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return 500, err
+	}
+	defer session.Close()
+
+	err = session.DB(DB.DatabaseName).C(DB.WebhookCollectionName).Update(bson.M{"id": webhook.ID}, &webhook)
+	if err == mgo.ErrNotFound {
+		return http.StatusNotFound, errors.New("No webhook with id " + webhook.ID)
+	}
+	if err != nil {
+		return 500, err
+	}
+	return http.StatusOK, nil
+}
+
+// SetWebhookStatus changes the status of the webhook with id id from from to status, and sets its failures to 0. If the status
+// isn't from anymore (it was changed by someone else since it was read), nothing is changed and 409 is returned.
+func (DB *MongoDB) SetWebhookStatus(id string, from string, status string) (Webhook, int, error) {
+	webhook := Webhook{}
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return webhook, 500, err
+	}
+	defer session.Close()
+
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"status": status, "failures": 0}}, ReturnNew: true}
+	_, err = session.DB(DB.DatabaseName).C(DB.WebhookCollectionName).Find(bson.M{"id": id, "status": statusIn(from)}).Apply(change, &webhook)
+	if err == mgo.ErrNotFound {
+		return webhook, http.StatusConflict, errors.New("The webhook with id " + id + " was changed meanwhile")
+	}
+	if err != nil {
+		return webhook, 500, err
+	}
+	return webhook, http.StatusOK, nil
+}
+
+// RecordWebhookDelivery counts a failed delivery to the webhook with id id, or sets its failures to 0 (and its DigestSentAt to digestSentAt,
+// if that isn't empty) when the delivery worked. An active webhook is disabled when it has failed maxFailures times in a row.
+// The changes are made in the database, so deliveries recorded at the same time by other processes aren't lost. It returns the
+// webhook after the change, and true if it was this delivery which disabled it.
+func (DB *MongoDB) RecordWebhookDelivery(id string, failed bool, digestSentAt string, maxFailures int) (Webhook, bool, int, error) {
+	webhook := Webhook{}
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return webhook, false, 500, err
+	}
+	defer session.Close()
+	collection := session.DB(DB.DatabaseName).C(DB.WebhookCollectionName)
+
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"failures": 0}}, ReturnNew: true}
+	if failed {
+		change.Update = bson.M{"$inc": bson.M{"failures": 1}}
+	} else if digestSentAt != "" {
+		change.Update = bson.M{"$set": bson.M{"failures": 0, "digestsentat": digestSentAt}}
+	}
+	_, err = collection.Find(bson.M{"id": id}).Apply(change, &webhook)
+	if err == mgo.ErrNotFound {
+		return webhook, false, http.StatusNotFound, errors.New("No webhook with id " + id)
+	}
+	if err != nil {
+		return webhook, false, 500, err
+	}
+	if !failed || webhook.Failures < maxFailures {
+		return webhook, false, http.StatusOK, nil
+	}
+
+	// Only matches while the webhook is active, so it is disabled once, and a webhook paused meanwhile stays paused
+	err = collection.Update(bson.M{"id": id, "status": statusIn("", WebhookActive), "failures": bson.M{"$gte": maxFailures}}, bson.M{"$set": bson.M{"status": WebhookDisabled}})
+	if err == mgo.ErrNotFound {
+		return webhook, false, http.StatusOK, nil
+	}
 	if err != nil {
-		return webhook, http.StatusBadRequest, err // Suppose the reason for error here is because it can't find webhook with the user-sent id.
+		return webhook, false, 500, err
 	}
-	return webhook, http.StatusFound, nil
+	webhook.Status = WebhookDisabled
+	return webhook, true, http.StatusOK, nil
+}
+
+// statusIn returns a filter for a status which is one of statuses. "" matches the webhooks stored before webhooks had a status too,
+// which don't have the field at all.
+func statusIn(statuses ...string) bson.M {
+	values := []interface{}{}
+	for _, status := range statuses {
+		if status == "" {
+			values = append(values, nil)
+		}
+		values = append(values, status)
+	}
+	return bson.M{"$in": values}
 }
 
 // DeleteWebhook deletes the webhook with id id.
@@ -188,10 +311,13 @@ func (DB *MongoDB) DeleteWebhook(id string) (int, error) {
 	defer session.Close()
 
 	err = session.DB(DB.DatabaseName).C(DB.WebhookCollectionName).Remove(bson.M{"id": id})
+	if err == mgo.ErrNotFound {
+		return http.StatusNotFound, errors.New("No webhook with id " + id)
+	}
 	if err != nil {
-		return http.StatusBadRequest, err // Suppose the reason for error here is because it can't find webhook with the user-sent id.
+		return 500, err
 	}
-	return http.StatusFound, nil
+	return http.StatusOK, nil
 }
 
 // GetLatest return the latest between currencies.
@@ -207,17 +333,96 @@ func (DB *MongoDB) GetLatest(baseCurrency string, targetCurrency string) (float3
 			return -1, "", 500, err
 		}*/
 	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(bson.M{"base": baseCurrency}).Sort("-date"). /*.Skip(dbSize - 1)*/ One(&latestCurrency) // Gotten from: https://stackoverflow.com/questions/38127583/get-last-inserted-element-from-mongodb-in-golang
+	if err == mgo.ErrNotFound {
+		return -1, "", http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency)
+	}
 	if err != nil {
-		return -1, "", http.StatusBadRequest, err
+		return -1, "", 500, err
 	}
 	val, ok := latestCurrency.Rates[targetCurrency] // This line + if sentence I have gotten from: https://stackoverflow.com/questions/2050391/how-to-check-if-a-map-contains-a-key-in-go
 	if !ok {                                        // If an error, ie. targetCurrency do not exist.
 		return -1, "", http.StatusBadRequest, errors.New("TargetCurrency not an accepted rate")
 	}
-	return val, latestCurrency.Date, http.StatusFound, nil
+	return val, latestCurrency.Date, http.StatusOK, nil
+}
+
+// GetLatestCurrency returns the latest stored snapshot (in date-order) for a base-currency.
+func (DB *MongoDB) GetLatestCurrency(baseCurrency string) (Currency, int, error) {
+	latestCurrency := Currency{}
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return latestCurrency, 500, err
+	}
+	defer session.Close()
+	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(bson.M{"base": baseCurrency}).Sort("-date").One(&latestCurrency)
+	if err == mgo.ErrNotFound {
+		return latestCurrency, http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency)
+	}
+	if err != nil {
+		return latestCurrency, 500, err
+	}
+	return latestCurrency, http.StatusOK, nil
+}
+
+// GetCurrencyAsOf returns the snapshot for a base-currency which was in effect on date (like 2017-11-20), which is the one from date,
+// or from the last business day before it if there is none from date (ie. it is a weekend or a holiday).
+func (DB *MongoDB) GetCurrencyAsOf(baseCurrency string, date string) (Currency, int, error) {
+	currency := Currency{}
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return currency, 500, err
+	}
+	defer session.Close()
+	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(bson.M{"base": baseCurrency, "date": bson.M{"$lte": date}}).Sort("-date").One(&currency) // The dates are yyyy-mm-dd, so they sort as strings
+	if err == mgo.ErrNotFound {
+		return currency, http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency + " on or before " + date)
+	}
+	if err != nil {
+		return currency, 500, err
+	}
+	return currency, http.StatusOK, nil
+}
+
+// CurrencyRevision returns the id of the newest stored snapshot (of any base-currency), which changes every time one is stored.
+// It only reads the _id-index, so it is cheap enough to be called by CachedStorage to see if the updater has stored something.
+func (DB *MongoDB) CurrencyRevision() (string, int, error) {
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return "", 500, err
+	}
+	defer session.Close()
+	newest := struct {
+		ID bson.ObjectId `bson:"_id"`
+	}{}
+	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(nil).Select(bson.M{"_id": 1}).Sort("-_id").One(&newest)
+	if err == mgo.ErrNotFound {
+		return "", http.StatusOK, nil // Nothing stored is a revision too
+	}
+	if err != nil {
+		return "", 500, err
+	}
+	return newest.ID.Hex(), http.StatusOK, nil
+}
+
+// GetCurrencies returns the count latest stored snapshots for a base-currency, the latest first.
+func (DB *MongoDB) GetCurrencies(baseCurrency string, count int) ([]Currency, int, error) {
+	currencies := []Currency{}
+	session, err := mgo.Dial(DB.DatabaseURL)
+	if err != nil {
+		return currencies, 500, err
+	}
+	defer session.Close()
+	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(bson.M{"base": baseCurrency}).Sort("-date").Limit(count).All(&currencies)
+	if err != nil {
+		return currencies, 500, err
+	}
+	if len(currencies) == 0 {
+		return currencies, http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency)
+	}
+	return currencies, http.StatusOK, nil
 }
 
-// GetAverage return the average between currencies the last 7 days.
+// GetAverage return the average between currencies of the last 3 stored snapshots of baseCurrency.
 func (DB *MongoDB) GetAverage(baseCurrency string, targetCurrency string) (float32, int, error) {
 	session, err := mgo.Dial(DB.DatabaseURL)
 	if err != nil {
@@ -225,23 +430,22 @@ func (DB *MongoDB) GetAverage(baseCurrency string, targetCurrency string) (float
 	}
 	defer session.Close()
 	latestCurrencies := []Currency{}
-	dbSize, err := session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Count()
+	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(bson.M{"base": baseCurrency}).Sort("-date").Limit(3).All(&latestCurrencies) // The last 3 snapshots of the base, the latest first
 	if err != nil {
 		return -1, 500, err
 	}
-	err = session.DB(DB.DatabaseName).C(DB.CurrencyCollectionName).Find(bson.M{"base": baseCurrency}).Sort("date").Skip(dbSize - 3).All(&latestCurrencies) // Get the last 7 entries (last seven days). Gotten from: https://stackoverflow.com/questions/38127583/get-last-inserted-element-from-mongodb-in-golang and https://stackoverflow.com/questions/27165692/how-to-get-all-element-from-mongodb-array-using-go
-	if err != nil {
-		return -1, http.StatusBadRequest, err
+	if len(latestCurrencies) < 3 {
+		return -1, http.StatusNotFound, errors.New("Only " + strconv.Itoa(len(latestCurrencies)) + " stored currencies with base " + baseCurrency + ", but the average needs 3")
 	}
 	var total float32
-	for i := 0; i < 3; i++ {
-		val, ok := latestCurrencies[i].Rates[targetCurrency] // This line + if sentence I have gotten from: https://stackoverflow.com/questions/2050391/how-to-check-if-a-map-contains-a-key-in-go
-		if !ok {                                             // If an error, ie. targetCurrency do not exist.
-			return -1, http.StatusBadRequest, errors.New("TargetCurrency not an accepted rate in " + string(i))
+	for _, currency := range latestCurrencies {
+		val, ok := currency.Rates[targetCurrency] // This line + if sentence I have gotten from: https://stackoverflow.com/questions/2050391/how-to-check-if-a-map-contains-a-key-in-go
+		if !ok {                                  // If an error, ie. targetCurrency do not exist.
+			return -1, http.StatusBadRequest, errors.New("TargetCurrency not an accepted rate on " + currency.Date)
 		}
 		total += val
 	}
-	return total / 3, http.StatusFound, nil // Return average of the last seven days
+	return total / 3, http.StatusOK, nil // Return average of the last 3 snapshots
 }
 
 // GetAllWebhooks gets all webhooks
@@ -254,7 +458,7 @@ func (DB *MongoDB) GetAllWebhooks() ([]Webhook, int, error) {
 	defer session.Close()
 	err = session.DB(DB.DatabaseName).C(DB.WebhookCollectionName).Find(bson.M{}).All(&webhooks) // Get all webhooks
 	if err != nil {
-		return webhooks, http.StatusBadRequest, err
+		return webhooks, 500, err
 	}
 	return webhooks, 200, nil
 }
@@ -285,198 +489,249 @@ func (DB *MongoDB) ResetCurrency() bool {
 	return true
 }
 
+// InTriggerRange checks if rate is between the webhook's min and max trigger-value. A trigger-value that is 0 is not set, and is not checked.
+func InTriggerRange(webhook Webhook, rate float32) bool {
+	if webhook.MinTriggerValue != 0 && rate < webhook.MinTriggerValue {
+		return false
+	}
+	if webhook.MaxTriggerValue != 0 && rate > webhook.MaxTriggerValue {
+		return false
+	}
+	return true
+}
+
 // SendWebhookFunc sends info to a webhook.
 func SendWebhookFunc(webhook Webhook, currentRate float32) (int, error) {
-	//if currentRate >= webhook.MinTriggerValue && currentRate <= webhook.MaxTriggerValue {		// If you should send a webhook request (check a second/last time).
-	sendWebhook := SendWebhook{}
-	sendWebhook.BaseCurrency = webhook.BaseCurrency
-	sendWebhook.CurrentRate = currentRate
-	sendWebhook.MaxTriggerValue = webhook.MaxTriggerValue
-	sendWebhook.MinTriggerValue = webhook.MinTriggerValue
-	sendWebhook.TargetCurrency = webhook.TargetCurrency
-
-	// This code gotten from: https://stackoverflow.com/questions/24455147/how-do-i-send-a-json-string-in-a-post-request-in-go
-	jsonStr := new(bytes.Buffer)
-	json.NewEncoder(jsonStr).Encode(&sendWebhook)
-	//println(jsonString)
-	//resp, err := http.Post(webhook.WebhookURL, "application/json", jsonStr)
-	req, err := http.NewRequest("POST", webhook.WebhookURL, jsonStr)
-	//req.Header.Set("X-Custom-Header", "myvalue")
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return http.StatusExpectationFailed, err // http.StatusExpectationFailed hears out to be the best when not connecting to url (or something there)
-	}
-	println(resp.StatusCode)
-	defer resp.Body.Close()
-	if resp.StatusCode == 200 || resp.StatusCode == 204 {
-		return http.StatusOK, nil // Has done the job without problems.
-	}
-	return http.StatusBadRequest, errors.New("We didn't get the correct statuscode, we got " + strconv.Itoa(resp.StatusCode) + ", but expected 200 or 204 when sending json ")
-	//}
-	//return http.StatusExpectationFailed, errors.New("Error when checking between values") // errors.New("Current rate is " + string(currentRate) + " which is between min and max, which is " + string(webhook.MinTriggerValue) + " and " + string(webhook.MaxTriggerValue))				// An error message if needed for debugging.
-}
-
-// SendMessageWebhook sends a message-webhook
-func SendMessageWebhook(msg MessageWebhook) bool {
-	// This code gotten from: https://stackoverflow.com/questions/24455147/how-do-i-send-a-json-string-in-a-post-request-in-go
-	jsonStr := new(bytes.Buffer)
-	json.NewEncoder(jsonStr).Encode(&msg)
-	resp, err := http.Post("https://hooks.zapier.com/hooks/catch/2217946/if5wx8/", "application/json", jsonStr)
+	_, statusCode, err := DeliverWebhook(Notification{Webhook: webhook, CurrentRate: currentRate})
+	return statusCode, err
+}
+
+// DeliverWebhook sends the notification to its webhook like SendWebhookFunc, and also returns what the receiver answered.
+func DeliverWebhook(notification Notification) (DeliveryResponse, int, error) {
+	if notification.Webhook.Kind == WebhookKindEmail {
+		return deliverEmail(notification)
+	}
+	body, contentType, err := BuildPayload(notification)
 	if err != nil {
-		return false
+		return DeliveryResponse{}, http.StatusInternalServerError, err
 	}
-	defer resp.Body.Close()
-	/*if resp.StatusCode == 200 || resp.StatusCode == 204 {
-		return true					// Has done the job without problems.
+	response, err := Delivery.Post(notification.Webhook.WebhookURL, contentType, body)
+	if err != nil {
+		return response, http.StatusExpectationFailed, err // http.StatusExpectationFailed hears out to be the best when not connecting to url (or something there)
 	}
-	return false*/
-	return true
+	if response.StatusCode == 200 || response.StatusCode == 204 {
+		return response, http.StatusOK, nil // Has done the job without problems.
+	}
+	return response, http.StatusBadRequest, errors.New("We didn't get the correct statuscode, we got " + strconv.Itoa(response.StatusCode) + ", but expected 200 or 204 when sending json ")
 }
 
-// RegisterWebhook registers, gets or deletes a webhook
+// SendMessageWebhook sends a message-webhook to the operator-sinks in Alerts, as info.
+// It returns false if a sink that wanted the message couldn't get it.
+func SendMessageWebhook(msg MessageWebhook) bool {
+	return SendAlert(SeverityInfo, msg)
+}
+
+// RegisterWebhook registers, gets or deletes a webhook. It serves the old /exchange paths through the api-router, so they work like before.
 func RegisterWebhook(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(r.URL.Path, "/") // Parts of response
-	fmt.Println(parts)
-	if len(parts) == 2 { // Is /exchange
-		if r.Method == "POST" {
-			webhook := Webhook{}
-			decodeErr := json.NewDecoder(r.Body).Decode(&webhook) // Get POST-request
-			if decodeErr != nil {
-				http.Error(w, "Bad request: Decoding didn't work on POST-input. "+decodeErr.Error(), http.StatusBadRequest)
-				return
-			}
-			if webhook.BaseCurrency != "EUR" { // If not implemented currency
-				http.Error(w, "Not implemented: We accept just Euro as base-currency, while you gave us "+webhook.BaseCurrency, http.StatusNotImplemented)
-				return
-			}
-			id, statusCode, err := DB.RegisterWebhookToDatabase(webhook)
-			if err != nil {
-				http.Error(w, "Something went wrong when registering a webhook: "+err.Error(), statusCode)
-				return
-			}
-			http.Header.Add(w.Header(), "content-type", "text")
-			w.WriteHeader(statusCode)
-			fmt.Fprintf(w, string(id))
-		} else {
-			http.Error(w, "Method not allowed: We only support POST for this functionality, but you used "+r.Method+".", http.StatusMethodNotAllowed)
-			return
-		}
-	} else if len(parts) == 3 { // Is /exchange/{id}
-		if r.Method == "GET" {
-			webhook, statusCode, err := DB.GetWebhook(parts[2]) // Get webhook by id from user
-			if err != nil {
-				http.Error(w, "Something went wrong when getting a webhook: "+err.Error(), statusCode)
-				return
-			}
-			http.Header.Add(w.Header(), "content-type", "application/json")
-			json.NewEncoder(w).Encode(&webhook)
+	legacyRouter.ServeHTTP(w, r)
+}
 
-		} else if r.Method == "DELETE" {
-			statusCode, err := DB.DeleteWebhook(parts[2]) // Delete webhook with id gotten by user
-			if err != nil {
-				http.Error(w, "Something went wrong when deleting a webhook: "+err.Error(), statusCode)
-				return
-			}
+var legacyRouter = NewAPIRouter()
 
-		} else {
-			http.Error(w, "Method not allowed: We only support GET and DELETE for this functionality, but you used "+r.Method+".", http.StatusMethodNotAllowed)
-			return
-		}
-	} else {
-		http.Error(w, "Bad request: You didn't give us enough/correct arguments", http.StatusBadRequest)
+// CreateWebhook registers the webhook in the body, and answers with it (and its id) as json.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook := Webhook{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&webhook) // Get POST-request
+	if decodeErr != nil {
+		writeError(w, http.StatusBadRequest, "Decoding didn't work on POST-input. "+decodeErr.Error(), nil)
+		return
+	}
+	if webhook.BaseCurrency != "EUR" { // If not implemented currency
+		writeError(w, http.StatusNotImplemented, "We accept just Euro as base-currency, while you gave us "+webhook.BaseCurrency, nil)
+		return
+	}
+	fieldErrors, statusCode, err := ValidateWebhook(webhook, DB)
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when validating a webhook: "+err.Error(), nil)
 		return
 	}
+	if len(fieldErrors) > 0 {
+		fieldErrors.Write(w)
+		return
+	}
+	webhook.Status = WebhookPending // Not active before the receiver has echoed the challenge
+	webhook.Failures = 0
+	webhook.DigestSentAt = ""
+	webhook.Challenge = NewChallenge()
+	id, statusCode, err := DB.RegisterWebhookToDatabase(webhook)
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when registering a webhook: "+err.Error(), nil)
+		return
+	}
+	webhook.ID = id
+	if _, verifyErr := VerifyWebhook(webhook); verifyErr != nil {
+		Events.Publish(VerificationFailed{webhook, verifyErr.Error()})
+	} else {
+		webhook.Status = WebhookActive
+	}
+	writeJSON(w, http.StatusCreated, &webhook)
 }
 
-// GetLatest gets the lates currency (in date-order)
-func GetLatest(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		currencyRequest := CurrencyRequest{}
-		decodeErr := json.NewDecoder(r.Body).Decode(&currencyRequest) // Get POST-request
-		if decodeErr != nil {
-			http.Error(w, "Bad request: Decoding didn't work on POST-input. "+decodeErr.Error(), http.StatusBadRequest)
-			return
-		}
-		/*if currencyRequest.BaseCurrency != "EUR" {
-			http.Error(w, "Not implemented: We only support Euro as baseCurrency. ", http.StatusNotImplemented)
-			return
-		}*/
+// GetWebhookHandler answers with the webhook with id id.
+func GetWebhookHandler(w http.ResponseWriter, r *http.Request, id string) {
+	webhook, statusCode, err := DB.GetWebhook(id) // Get webhook by id from user
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when getting a webhook: "+err.Error(), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, &webhook)
+}
 
-		latestCurrency, _, statusCode, err := DB.GetLatest(currencyRequest.BaseCurrency, currencyRequest.TargetCurrency) // Get latest currency from database
-		if err != nil {
-			http.Error(w, "We got an error while getting latest currency: "+err.Error(), statusCode)
-			return
-		}
+// DeleteWebhookHandler deletes the webhook with id id.
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request, id string) {
+	statusCode, err := DB.DeleteWebhook(id) // Delete webhook with id gotten by user
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when deleting a webhook: "+err.Error(), nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		http.Header.Add(w.Header(), "content-type", "text")
-		w.WriteHeader(statusCode)
-		fmt.Fprintf(w, FloatToString(latestCurrency))
+// TestFireResult - This is the struct which tells the user how a test-fired webhook was received.
+type TestFireResult struct {
+	ID              string `json:"id"`
+	Payload         string `json:"payload"`
+	ContentType     string `json:"contentType"`
+	Date            string `json:"date"`
+	StatusCode      int    `json:"statusCode"`
+	LatencyMs       int64  `json:"latencyMs"`
+	ResponseSnippet string `json:"responseSnippet"`
+	Error           string `json:"error,omitempty"`
+}
 
-	} else {
-		http.Error(w, "Method not allowed: We only support POST for this functionality, but you used "+r.Method+".", http.StatusNotImplemented)
+// TestWebhook sends the latest rate to the webhook with id id, even if the rate is outside the trigger-range, and tells how the receiver answered.
+func TestWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	webhook, statusCode, err := DB.GetWebhook(id)
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when getting a webhook: "+err.Error(), nil)
+		return
+	}
+	if webhook.State() == WebhookPending {
+		writeError(w, http.StatusConflict, "The webhook is pending, and can't be test-fired before it is verified.", nil)
+		return
+	}
+	currencies, statusCode, err := DB.GetCurrencies(webhook.BaseCurrency, 2)
+	if err != nil {
+		writeError(w, statusCode, "We got an error while getting latest currency: "+err.Error(), nil)
+		return
+	}
+	notification, err := NewNotification(webhook, currencies)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "We got an error while getting latest currency: "+err.Error(), nil)
 		return
 	}
+
+	result := TestFireResult{ID: webhook.ID, Date: notification.Date}
+	payload, contentType, _ := BuildPayload(notification)
+	result.Payload, result.ContentType = string(payload), contentType
+	response, _, err := DeliverWebhook(notification)
+	result.StatusCode = response.StatusCode
+	result.LatencyMs = int64(response.Latency / time.Millisecond)
+	result.ResponseSnippet = string(response.Body)
+	if len(result.ResponseSnippet) > 512 {
+		result.ResponseSnippet = result.ResponseSnippet[:512]
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	writeJSON(w, http.StatusOK, &result)
 }
 
-// GetAverage gets the average of the last 3 currencies
-func GetAverage(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		currencyRequest := CurrencyRequest{}
-		decodeErr := json.NewDecoder(r.Body).Decode(&currencyRequest) // Get POST-request
-		if decodeErr != nil {
-			http.Error(w, "Bad request: Decoding didn't work on POST-input. "+decodeErr.Error(), http.StatusBadRequest)
+// GetLatest gets the lates currency (in date-order). It takes a CurrencyRequest as POST, or ?base=EUR&target=NOK as GET (see readRateQuery).
+// With asOf it gets the currency in effect on that date instead. It answers in json, csv or xml (see negotiateFormat).
+func GetLatest(w http.ResponseWriter, r *http.Request) {
+	query, ok := readRateQuery(w, r)
+	if !ok {
+		return
+	}
+	latestCurrency, statusCode, err := snapshotFor(query) // Get latest currency from database, with all the targets
+	if err != nil {
+		writeError(w, statusCode, "We got an error while getting latest currency: "+err.Error(), nil)
+		return
+	}
+	rates := []RateResponse{}
+	for _, target := range query.TargetCurrencies {
+		rate, ok := latestCurrency.Rates[target]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "We got an error while getting latest currency: TargetCurrency "+target+" not an accepted rate", nil)
 			return
 		}
-		/*if currencyRequest.BaseCurrency != "EUR" {
-			http.Error(w, "Not implemented: We only support Euro as baseCurrency. ", http.StatusNotImplemented)
-			return
-		}*/
+		rates = append(rates, RateResponse{query.BaseCurrency, target, rate, latestCurrency.Date, query.AsOf})
+	}
+	writeRates(w, r, rates)
+}
 
-		averageCurrency, statusCode, err := DB.GetAverage(currencyRequest.BaseCurrency, currencyRequest.TargetCurrency) // Get the average currency from database
+// GetAverage gets the average of the last 3 currencies. It takes the same requests as GetLatest, except asOf.
+func GetAverage(w http.ResponseWriter, r *http.Request) {
+	query, ok := readRateQuery(w, r)
+	if !ok {
+		return
+	}
+	if query.AsOf != "" { // The average is always of the last 3 snapshots, so we don't pretend to answer for another date
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{APIError{ErrorCodeValidation, "The request didn't validate.", ValidationErrors{{"asOf", "can't be used with the average, which is always of the last 3 rates"}}}})
+		return
+	}
+	// The average changes with the latest snapshot, so it can be cached until then. Its date is read before the averages,
+	// so a snapshot stored meanwhile makes the ETag older than the answer, and never newer.
+	latestCurrency, _, latestErr := DB.GetLatestCurrency(query.BaseCurrency)
+	rates := []RateResponse{}
+	for _, target := range query.TargetCurrencies {
+		averageCurrency, statusCode, err := DB.GetAverage(query.BaseCurrency, target) // Get the average currency from database
 		if err != nil {
-			http.Error(w, "We got an error while getting average currency: "+err.Error(), statusCode)
+			writeError(w, statusCode, "We got an error while getting average currency: "+err.Error(), nil)
 			return
 		}
-
-		// TODO: Give output to user
-		http.Header.Add(w.Header(), "content-type", "text")
-		w.WriteHeader(statusCode)
-		fmt.Fprintf(w, FloatToString(averageCurrency))
-
-	} else {
-		http.Error(w, "Not implemented: We only support POST for this functionality.", http.StatusNotImplemented)
+		rates = append(rates, RateResponse{BaseCurrency: query.BaseCurrency, TargetCurrency: target, Rate: averageCurrency})
+	}
+	if latestErr == nil && notModified(w, r, latestCurrency.Date, "average", time.Now()) { // Only answers that worked get caching-headers
 		return
 	}
+	writeRatesJSON(w, rates)
 }
 
-// EvaluationTrigger triggers all webhooks to be sent
+// EvaluationTrigger evaluates all webhooks against the latest currency and sends the ones inside their trigger-range.
+// With ?dryRun=true nothing is sent. Either way a json-report with the outcome for every webhook is returned.
 func EvaluationTrigger(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
+		dryRun := false
+		if value := r.URL.Query().Get("dryRun"); value != "" {
+			var parseErr error
+			dryRun, parseErr = strconv.ParseBool(value)
+			if parseErr != nil {
+				writeError(w, http.StatusBadRequest, "dryRun must be true or false, but you gave us "+value, nil)
+				return
+			}
+		}
+
 		webhooks, statusCode, err := DB.GetAllWebhooks()
 		if err != nil {
-			http.Error(w, "Failed to get all webhooks from database. Error: "+err.Error(), statusCode)
+			writeError(w, statusCode, "Failed to get all webhooks from database. Error: "+err.Error(), nil)
 			return
 		}
 
-		for i := 0; i < len(webhooks); i++ {
-			//fmt.Fprint(w, string(webhooks[i].WebhookURL))
-			baseCurrency := webhooks[i].BaseCurrency
-			rateCurrency := webhooks[i].TargetCurrency
-			latestCurrency, _, statusCode, err := DB.GetLatest(baseCurrency, rateCurrency) // Get latest currency from database
-			statusCode, err = SendWebhookFunc(webhooks[i], latestCurrency)                 // Sends latest currency
-			if err != nil {
-				http.Error(w, "Failed to send webhook number "+strconv.Itoa(i)+" from database (will not send any more webhooks if any). Error:"+err.Error(), statusCode)
-				return
-			}
+		var report DispatchReport
+		if dryRun {
+			report = Dispatch.DryRun(webhooks, LatestEvaluator(DB))
+		} else {
+			report = Dispatch.Run(webhooks, LatestEvaluator(DB))
+			RecordDeliveries(DB, webhooks, report)
 		}
 
-		w.WriteHeader(http.StatusOK)
+		writeJSON(w, http.StatusOK, &report)
 
 	} else {
-		http.Error(w, "Not implemented: We only support GET here", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "We only support GET for this functionality, but you used "+r.Method+".", nil)
 	}
 }
 
@@ -486,17 +741,17 @@ func BotGetLatest(w http.ResponseWriter, r *http.Request) {
 		botRequest := BotRequest{}
 		decodeErr := json.NewDecoder(r.Body).Decode(&botRequest) // Get POST-request
 		if decodeErr != nil {
-			http.Error(w, "Bad request: Decoding didn't work on POST-input. "+decodeErr.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Decoding didn't work on POST-input. "+decodeErr.Error(), nil)
 			return
 		}
 		/*if botRequest.Result.Parameters.BaseCurrency != "EUR" {
-			http.Error(w, "Not implemented: We only support Euro as baseCurrency. ", http.StatusNotImplemented)
+			writeError(w, http.StatusNotImplemented, "We only support Euro as baseCurrency. ", nil)
 			return
 		}*/
 
 		latestCurrency, _, statusCode, err := DB.GetLatest(botRequest.Result.Parameters.BaseCurrency, botRequest.Result.Parameters.TargetCurrency) // Get latest currency from database
 		if err != nil {
-			http.Error(w, "We got an error while getting latest currency: "+err.Error(), statusCode)
+			writeError(w, statusCode, "We got an error while getting latest currency: "+err.Error(), nil)
 			return
 		}
 
@@ -506,12 +761,10 @@ func BotGetLatest(w http.ResponseWriter, r *http.Request) {
 		botAnswer.DisplayText = response
 
 		// Return answer to bot:
-		http.Header.Add(w.Header(), "content-type", "application/json")
-		w.WriteHeader(statusCode)
-		json.NewEncoder(w).Encode(&botAnswer)
+		writeJSON(w, http.StatusOK, &botAnswer)
 
 	} else {
-		http.Error(w, "Method not allowed: We only support POST for this functionality, but you used "+r.Method+".", http.StatusNotImplemented)
+		writeError(w, http.StatusMethodNotAllowed, "We only support POST for this functionality, but you used "+r.Method+".", nil)
 		return
 	}
 }
@@ -3,11 +3,12 @@ package exchange
 import "testing"
 import "fmt"
 import "time"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+import "strings"
 
-//import "net/http"
-//import "net/http/httptest"
 //import "bytes"
-//import "encoding/json"
 //import "io/ioutil"
 
 var testdb Storage
@@ -161,6 +162,38 @@ func Test_GetAverage(t *testing.T) {
 	}
 }
 
+func Test_Handler_TestWebhook(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendWebhook := SendWebhook{}
+		json.NewDecoder(r.Body).Decode(&sendWebhook)
+		w.Write([]byte("got " + FloatToString(sendWebhook.CurrentRate)))
+	}))
+	defer receiver.Close()
+	Delivery = setupTestDelivery(DefaultDeliveryConfig())
+	db := &memoryStorage{}
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6}})
+	id, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: receiver.URL, BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 20})
+	DB = db
+
+	w := httptest.NewRecorder()
+	RegisterWebhook(w, httptest.NewRequest("POST", "/exchange/"+id+"/test", strings.NewReader("")))
+	if w.Code != http.StatusOK {
+		t.Error("Expected statuscode 200 when test-firing a webhook, got ", w.Code, ": ", w.Body.String())
+		return
+	}
+	result := TestFireResult{}
+	json.NewDecoder(w.Body).Decode(&result)
+	if result.StatusCode != http.StatusOK || result.ResponseSnippet != "got 9.6" || result.Error != "" {
+		t.Error("Unexpected result from test-firing a webhook: ", result)
+	}
+
+	w = httptest.NewRecorder()
+	RegisterWebhook(w, httptest.NewRequest("POST", "/exchange/unknown/test", strings.NewReader("")))
+	if w.Code == http.StatusOK {
+		t.Error("Expected an error when test-firing an unknown webhook")
+	}
+}
+
 /*func Test_Handler_GetLatest(t *testing.T) {
 	fmt.Println("Starting handler func")
 	setupTestdatabase()                 //?
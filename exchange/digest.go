@@ -0,0 +1,395 @@
+package exchange
+
+import "bytes"
+import "encoding/json"
+import "errors"
+import htmltemplate "html/template"
+import "net/http"
+import "sort"
+import "strconv"
+import "sync"
+import "time"
+
+// How often a digest is sent. Webhooks without a digest get one notification per triggered pair.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// maxDiscordEmbeds is how many embeds Discord accepts in one message.
+const maxDiscordEmbeds = 10
+
+// DigestRate - This is the struct which holds one triggered pair in a digest.
+type DigestRate struct {
+	ID              string  `json:"id"`
+	BaseCurrency    string  `json:"baseCurrency"`
+	TargetCurrency  string  `json:"targetCurrency"`
+	CurrentRate     float32 `json:"currentRate"`
+	PreviousRate    float32 `json:"previousRate,omitempty"`
+	MinTriggerValue float32 `json:"minTriggerValue"`
+	MaxTriggerValue float32 `json:"maxTriggerValue"`
+	Date            string  `json:"date"`
+}
+
+// DigestPayload - This is the struct which is sent to json-webhooks with a digest.
+type DigestPayload struct {
+	Type   string       `json:"type"` // Always "digest"
+	Period string       `json:"period"`
+	Date   string       `json:"date"`
+	Count  int          `json:"count"`
+	Rates  []DigestRate `json:"rates"`
+}
+
+// Digest - This is the struct which holds the triggered pairs going to one destination.
+type Digest struct {
+	Kind          string
+	WebhookURL    string
+	Email         string
+	Period        string
+	Webhooks      []Webhook      // Every digest-webhook to this destination, also the ones that didn't trigger
+	Notifications []Notification // The triggered pairs
+}
+
+// DigestResult - This is the struct which holds the outcome of sending one digest.
+type DigestResult struct {
+	Destination string `json:"destination"`
+	Period      string `json:"period"`
+	Pairs       int    `json:"pairs"`
+	Sent        bool   `json:"sent"`
+	Reason      string `json:"reason"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// DigestCollector collects the triggered digest-webhooks during the updater runs, grouped by destination, so every destination
+// gets one digest with all its pairs when Send is called and the digest is due. The pairs are kept until their digest is sent,
+// so a weekly digest also has the pairs which triggered on the days it wasn't due. They are kept in memory, like the updater-loop.
+type DigestCollector struct {
+	mutex   sync.Mutex
+	digests map[string]*Digest
+	order   []string
+}
+
+// NewDigestCollector makes an empty collector.
+func NewDigestCollector() *DigestCollector {
+	return &DigestCollector{digests: map[string]*Digest{}}
+}
+
+func digestDestination(webhook Webhook) string {
+	if webhook.Kind == WebhookKindEmail {
+		return webhook.Email
+	}
+	return webhook.WebhookURL
+}
+
+// Collect evaluates the active digest-webhooks with evaluate, the same way the dispatcher does, and keeps the ones that fire.
+// It can be called once per base-currency in a run. A pair which fires again replaces what was kept of it, so the digest has
+// its latest rate. webhooks must be every stored webhook, since the kept pairs of the ones which aren't active digest-webhooks
+// with the same destination anymore (deleted, paused or changed) are dropped.
+func (collector *DigestCollector) Collect(webhooks []Webhook, evaluate Evaluator) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	keys := map[string]string{} // The digest of every active digest-webhook
+	for _, webhook := range webhooks {
+		if webhook.Digest != "" && webhook.IsActive() {
+			keys[webhook.ID] = webhook.Kind + " " + webhook.Digest + " " + digestDestination(webhook)
+		}
+	}
+	collector.dropInactive(keys)
+
+	for _, webhook := range webhooks {
+		key, ok := keys[webhook.ID]
+		if !ok {
+			continue
+		}
+		digest, ok := collector.digests[key]
+		if !ok {
+			digest = &Digest{Kind: webhook.Kind, WebhookURL: webhook.WebhookURL, Email: webhook.Email, Period: webhook.Digest}
+			collector.digests[key] = digest
+			collector.order = append(collector.order, key)
+		}
+		digest.add(webhook)
+		if notification, fire, _ := evaluate(webhook); fire {
+			digest.addNotification(notification)
+		}
+	}
+}
+
+// dropInactive removes the webhooks which don't belong to the digest in keys anymore, and the digests left without webhooks.
+func (collector *DigestCollector) dropInactive(keys map[string]string) {
+	order := []string{}
+	for _, key := range collector.order {
+		digest := collector.digests[key]
+		webhooks, notifications := []Webhook{}, []Notification{}
+		for _, webhook := range digest.Webhooks {
+			if keys[webhook.ID] == key {
+				webhooks = append(webhooks, webhook)
+			}
+		}
+		for _, notification := range digest.Notifications {
+			if keys[notification.Webhook.ID] == key {
+				notifications = append(notifications, notification)
+			}
+		}
+		if len(webhooks) == 0 {
+			delete(collector.digests, key)
+			continue
+		}
+		digest.Webhooks, digest.Notifications = webhooks, notifications
+		order = append(order, key)
+	}
+	collector.order = order
+}
+
+// add adds webhook to the digest, or replaces the copy it has, so DigestSentAt is the stored one.
+func (digest *Digest) add(webhook Webhook) {
+	for i, added := range digest.Webhooks {
+		if added.ID == webhook.ID {
+			digest.Webhooks[i] = webhook
+			return
+		}
+	}
+	digest.Webhooks = append(digest.Webhooks, webhook)
+}
+
+// addNotification adds the triggered pair to the digest, or replaces the one kept from an earlier run.
+func (digest *Digest) addNotification(notification Notification) {
+	for i, added := range digest.Notifications {
+		if added.Webhook.ID == notification.Webhook.ID {
+			digest.Notifications[i] = notification
+			return
+		}
+	}
+	digest.Notifications = append(digest.Notifications, notification)
+}
+
+// Digests returns the collected digests, in the order their destinations were first seen.
+func (collector *DigestCollector) Digests() []Digest {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	digests := []Digest{}
+	for _, key := range collector.order {
+		digest := *collector.digests[key]
+		digest.Webhooks = append([]Webhook{}, digest.Webhooks...) // Copied, so Collect can change the collected ones while these are sent
+		digest.Notifications = append([]Notification{}, digest.Notifications...)
+		digests = append(digests, digest)
+	}
+	return digests
+}
+
+// LastSent returns the date of the last digest sent to this destination, or an empty string if none has been sent.
+func (digest Digest) LastSent() string {
+	lastSent := ""
+	for _, webhook := range digest.Webhooks {
+		if webhook.DigestSentAt > lastSent {
+			lastSent = webhook.DigestSentAt
+		}
+	}
+	return lastSent
+}
+
+// IsDue tells if the digest should be sent now, ie. none has been sent today (daily) or this week (weekly, the weeks start on Monday).
+// It goes by the calendar, and not by the time since the last one, so a late run doesn't move the digests after it.
+func (digest Digest) IsDue(now time.Time) bool {
+	lastSent, err := time.Parse("2006-01-02", digest.LastSent())
+	if err != nil {
+		return true
+	}
+	today, _ := time.Parse("2006-01-02", now.Format("2006-01-02"))
+	if digest.Period == DigestWeekly {
+		year, week := today.ISOWeek()
+		sentYear, sentWeek := lastSent.ISOWeek()
+		return today.After(lastSent) && (year != sentYear || week != sentWeek)
+	}
+	return today.After(lastSent)
+}
+
+// Send sends the digests which are due and have triggered pairs, and stores when they were sent. The pairs of a digest
+// are kept for the next call until it has been sent without an error.
+func (collector *DigestCollector) Send(storage Storage, now time.Time) []DigestResult {
+	results := []DigestResult{}
+	for _, digest := range collector.Digests() {
+		result := DigestResult{Destination: digestDestination(digest.Webhooks[0]), Period: digest.Period, Pairs: len(digest.Notifications)}
+		if len(digest.Notifications) == 0 {
+			result.Reason = "No pairs inside their trigger-range"
+		} else if !digest.IsDue(now) {
+			result.Reason = "The " + digest.Period + " digest was sent " + digest.LastSent()
+		} else {
+			result.Sent = true
+			result.Reason = "Sent " + strconv.Itoa(len(digest.Notifications)) + " pairs"
+			_, statusCode, err := DeliverDigest(digest, now)
+			result.StatusCode = statusCode
+			if err != nil {
+				result.Error = err.Error()
+			}
+			recordDigest(storage, digest, now, err)
+			if err == nil {
+				collector.remove(digest, now)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// remove removes the pairs of sent from the collector, but not the ones collected while it was sent, and stores that it was sent now.
+func (collector *DigestCollector) remove(sent Digest, now time.Time) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	for _, key := range collector.order {
+		digest := collector.digests[key]
+		for i, webhook := range digest.Webhooks {
+			if sent.hasWebhook(webhook.ID) {
+				digest.Webhooks[i].DigestSentAt = now.Format("2006-01-02")
+			}
+		}
+		notifications := []Notification{}
+		for _, notification := range digest.Notifications {
+			if !sent.hasNotification(notification) {
+				notifications = append(notifications, notification)
+			}
+		}
+		digest.Notifications = notifications
+	}
+}
+
+func (digest Digest) hasWebhook(id string) bool {
+	for _, webhook := range digest.Webhooks {
+		if webhook.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (digest Digest) hasNotification(notification Notification) bool {
+	for _, added := range digest.Notifications {
+		if added.Webhook.ID == notification.Webhook.ID && added.Date == notification.Date {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDigest stores the date of the digest on every webhook in it, or counts the failure like RecordDeliveries does.
+func recordDigest(storage Storage, digest Digest, now time.Time, err error) {
+	for _, webhook := range digest.Webhooks {
+		recordDelivery(storage, webhook, err != nil, now.Format("2006-01-02"))
+	}
+}
+
+// sortedPayloadData returns the payload-data of the triggered pairs, sorted by pair so the digest is easy to read.
+func (digest Digest) sortedPayloadData() []PayloadData {
+	data := []PayloadData{}
+	for _, notification := range digest.Notifications {
+		data = append(data, NewPayloadData(notification))
+	}
+	sort.SliceStable(data, func(i, j int) bool { return data[i].Pair < data[j].Pair })
+	return data
+}
+
+func digestTitle(digest Digest) string {
+	if digest.Period == DigestWeekly {
+		return "Weekly currency digest"
+	}
+	return "Daily currency digest"
+}
+
+// BuildDigestPayload returns the body and content-type of the digest for its kind. Email-digests are built with BuildDigestEmail.
+func BuildDigestPayload(digest Digest, now time.Time) ([]byte, string, error) {
+	data := digest.sortedPayloadData()
+	summary := digestTitle(digest) + ": " + strconv.Itoa(len(data)) + " pairs inside their trigger-range"
+	switch digest.Kind {
+	case WebhookKindSlack:
+		message := SlackMessage{Text: summary, Attachments: []SlackAttachment{}}
+		for _, pair := range data {
+			message.Attachments = append(message.Attachments, slackAttachment(pair))
+		}
+		payload, err := json.Marshal(&message)
+		return payload, "application/json", err
+	case WebhookKindDiscord:
+		message := DiscordMessage{Content: summary, Embeds: []DiscordEmbed{}}
+		for i, pair := range data {
+			if i == maxDiscordEmbeds {
+				message.Content += " (showing the first " + strconv.Itoa(maxDiscordEmbeds) + ")"
+				break
+			}
+			message.Embeds = append(message.Embeds, discordEmbed(pair))
+		}
+		payload, err := json.Marshal(&message)
+		return payload, "application/json", err
+	}
+
+	payload := DigestPayload{Type: "digest", Period: digest.Period, Date: now.Format("2006-01-02"), Count: len(data), Rates: []DigestRate{}}
+	for _, notification := range digest.Notifications {
+		webhook := notification.Webhook
+		payload.Rates = append(payload.Rates, DigestRate{webhook.ID, webhook.BaseCurrency, webhook.TargetCurrency, notification.CurrentRate, notification.PreviousRate, webhook.MinTriggerValue, webhook.MaxTriggerValue, notification.Date})
+	}
+	sort.SliceStable(payload.Rates, func(i, j int) bool {
+		return payload.Rates[i].BaseCurrency+payload.Rates[i].TargetCurrency < payload.Rates[j].BaseCurrency+payload.Rates[j].TargetCurrency
+	})
+	body, err := json.Marshal(&payload)
+	return body, "application/json", err
+}
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest").Parse(`<html><body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<table>
+<tr><th>Pair</th><th>Rate</th><th>Trigger-range</th><th>Change</th><th>Date</th></tr>
+{{range .Rows}}<tr><td>{{.Pair}}</td><td><b>{{.Rate}}</b></td><td>{{.Band}}</td><td>{{.Change}}</td><td>{{.Date}}</td></tr>
+{{end}}</table>
+</body></html>`))
+
+// BuildDigestEmail renders the digest as the subject, plain-text and html body of an email.
+func BuildDigestEmail(digest Digest) (string, string, string, error) {
+	type row struct{ Pair, Rate, Band, Change, Date string }
+	fields := struct {
+		Title string
+		Rows  []row
+	}{Title: digestTitle(digest)}
+	text := fields.Title + "\r\n\r\n"
+	for _, data := range digest.sortedPayloadData() {
+		current := row{data.Pair, FloatToString(data.CurrentRate) + " " + trendArrow(data), triggerBand(data), changeText(data), data.Date}
+		fields.Rows = append(fields.Rows, current)
+		text += current.Pair + ": " + current.Rate + " (trigger-range " + current.Band + ", change " + current.Change + ", " + current.Date + ")\r\n"
+	}
+	subject := fields.Title + ": " + strconv.Itoa(len(fields.Rows)) + " pairs inside their trigger-range"
+	html := new(bytes.Buffer)
+	if err := digestHTML.Execute(html, fields); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, html.String(), nil
+}
+
+// DeliverDigest sends the digest to its destination, by email or as a post like DeliverWebhook.
+func DeliverDigest(digest Digest, now time.Time) (DeliveryResponse, int, error) {
+	if digest.Kind == WebhookKindEmail {
+		if Mail == nil {
+			return DeliveryResponse{}, http.StatusInternalServerError, errors.New("Email is not configured on this server (SMTP_HOST is not set)")
+		}
+		subject, text, html, err := BuildDigestEmail(digest)
+		if err != nil {
+			return DeliveryResponse{}, http.StatusInternalServerError, err
+		}
+		start := time.Now()
+		err = Mail.Send([]string{digest.Email}, subject, text, html)
+		response := DeliveryResponse{Latency: time.Since(start)}
+		if err != nil {
+			return response, http.StatusExpectationFailed, err
+		}
+		return response, http.StatusOK, nil
+	}
+
+	body, contentType, err := BuildDigestPayload(digest, now)
+	if err != nil {
+		return DeliveryResponse{}, http.StatusInternalServerError, err
+	}
+	response, err := Delivery.Post(digest.WebhookURL, contentType, body)
+	if err != nil {
+		return response, http.StatusExpectationFailed, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response, http.StatusBadRequest, errors.New("The receiver answered the digest with statuscode " + strconv.Itoa(response.StatusCode))
+	}
+	return response, http.StatusOK, nil
+}
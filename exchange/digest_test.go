@@ -0,0 +1,155 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "time"
+
+func Test_DigestCollector_Send(t *testing.T) {
+	var payloads []DigestPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := DigestPayload{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+	}))
+	defer receiver.Close()
+	Delivery = setupTestDelivery(DefaultDeliveryConfig())
+
+	db := &memoryStorage{}
+	register := func(webhook Webhook) string {
+		id, _, _ := db.RegisterWebhookToDatabase(webhook)
+		return id
+	}
+	nokID := register(Webhook{WebhookURL: receiver.URL, Digest: DigestDaily, BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9})
+	usdID := register(Webhook{WebhookURL: receiver.URL, Digest: DigestDaily, BaseCurrency: "USD", TargetCurrency: "SEK", MaxTriggerValue: 10})
+	register(Webhook{WebhookURL: receiver.URL, Digest: DigestDaily, BaseCurrency: "EUR", TargetCurrency: "SEK", MinTriggerValue: 20}) // Outside
+	register(Webhook{WebhookURL: receiver.URL, BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9})                       // No digest
+	webhooks, _, _ := db.GetAllWebhooks()
+
+	collector := NewDigestCollector()
+	collector.Collect(webhooks, SnapshotEvaluator(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6, "SEK": 9.9}}, Currency{}))
+	collector.Collect(webhooks, SnapshotEvaluator(Currency{"USD", "2017-11-20", map[string]float32{"SEK": 8.4}}, Currency{}))
+	now := time.Date(2017, 11, 20, 18, 0, 0, 0, time.UTC)
+	results := collector.Send(db, now)
+	if len(results) != 1 || !results[0].Sent || results[0].Error != "" || results[0].Pairs != 2 {
+		t.Error("Expected one digest with 2 pairs to be sent, got ", results)
+	}
+	if len(payloads) != 1 || payloads[0].Count != 2 || payloads[0].Rates[0].BaseCurrency != "EUR" || payloads[0].Rates[1].BaseCurrency != "USD" || payloads[0].Period != DigestDaily {
+		t.Error("Expected one daily digest with EUR/NOK and USD/SEK, got ", payloads)
+	}
+	for _, id := range []string{nokID, usdID} {
+		if webhook, _, _ := db.GetWebhook(id); webhook.DigestSentAt != "2017-11-20" {
+			t.Error("Expected the date of the digest to be stored, got " + webhook.DigestSentAt)
+		}
+	}
+
+	webhooks, _, _ = db.GetAllWebhooks()
+	collector.Collect(webhooks, SnapshotEvaluator(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6}}, Currency{}))
+	if results = collector.Send(db, now.Add(time.Hour)); len(results) != 1 || results[0].Sent || len(payloads) != 1 {
+		t.Error("Expected no second daily digest the same day, got ", results)
+	}
+}
+
+func Test_DigestCollector_KeepsPairsUntilSent(t *testing.T) {
+	var payloads []DigestPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := DigestPayload{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+	}))
+	defer receiver.Close()
+	Delivery = setupTestDelivery(DefaultDeliveryConfig())
+
+	db := &memoryStorage{}
+	db.RegisterWebhookToDatabase(Webhook{WebhookURL: receiver.URL, Digest: DigestWeekly, BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, DigestSentAt: "2017-11-20"})
+	db.RegisterWebhookToDatabase(Webhook{WebhookURL: receiver.URL, Digest: DigestWeekly, BaseCurrency: "EUR", TargetCurrency: "SEK", MinTriggerValue: 9, DigestSentAt: "2017-11-20"})
+	pausedID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: receiver.URL, Digest: DigestWeekly, BaseCurrency: "EUR", TargetCurrency: "USD", MaxTriggerValue: 2, DigestSentAt: "2017-11-20"})
+	collector := NewDigestCollector()
+
+	days := []struct {
+		date  string
+		rates map[string]float32
+	}{
+		{"2017-11-21", map[string]float32{"NOK": 9.6, "SEK": 8.5, "USD": 1.1}}, // NOK and USD trigger, but the digest was sent Monday
+		{"2017-11-22", map[string]float32{"NOK": 8.9, "SEK": 9.8, "USD": 1.2}}, // SEK triggers
+		{"2017-11-27", map[string]float32{"NOK": 8.8, "SEK": 8.7, "USD": 1.3}}, // Nothing triggers, but a new week has started
+	}
+	for i, day := range days {
+		if i == 1 {
+			webhook, _, _ := db.GetWebhook(pausedID)
+			webhook.Status = WebhookPaused
+			db.UpdateWebhook(webhook)
+		}
+		webhooks, _, _ := db.GetAllWebhooks()
+		collector.Collect(webhooks, SnapshotEvaluator(Currency{"EUR", day.date, day.rates}, Currency{}))
+		now, _ := time.Parse("2006-01-02", day.date)
+		results := collector.Send(db, now.Add(18*time.Hour))
+		if sent := len(results) == 1 && results[0].Sent; sent != (day.date == "2017-11-27") {
+			t.Error("Unexpected digest on "+day.date+": ", results)
+		}
+	}
+	if len(payloads) != 1 || payloads[0].Count != 2 || payloads[0].Rates[0].TargetCurrency != "NOK" || payloads[0].Rates[0].Date != "2017-11-21" || payloads[0].Rates[1].TargetCurrency != "SEK" {
+		t.Error("Expected the pairs from the days the digest wasn't due, without the paused webhook, got ", payloads)
+	}
+	if digests := collector.Digests(); len(digests) != 1 || len(digests[0].Notifications) != 0 {
+		t.Error("Expected the sent pairs to be removed from the collector, got ", digests)
+	}
+}
+
+func Test_Digest_IsDue(t *testing.T) {
+	now := time.Date(2017, 11, 22, 8, 0, 0, 0, time.UTC) // A Wednesday
+	tests := []struct {
+		period   string
+		sentAt   string
+		expected bool
+	}{
+		{DigestDaily, "", true},
+		{DigestDaily, "2017-11-22", false},
+		{DigestDaily, "2017-11-21", true},
+		{DigestWeekly, "", true},
+		{DigestWeekly, "2017-11-20", false}, // Monday this week
+		{DigestWeekly, "2017-11-19", true},  // Sunday last week, so a late run last week doesn't move this week's digest
+		{DigestWeekly, "2017-11-15", true},
+	}
+	for _, test := range tests {
+		digest := Digest{Period: test.period, Webhooks: []Webhook{{DigestSentAt: test.sentAt}}}
+		if digest.IsDue(now) != test.expected {
+			t.Error("Expected IsDue to be ", test.expected, " for a ", test.period, " digest sent ", test.sentAt)
+		}
+	}
+}
+
+func Test_Dispatcher_SkipsDigests(t *testing.T) {
+	sent := 0
+	dispatcher := &Dispatcher{Workers: 1, PerHost: 1, Send: func(notification Notification) (int, error) {
+		sent++
+		return http.StatusOK, nil
+	}}
+	webhooks := []Webhook{{ID: "1", WebhookURL: "http://example.com", Digest: DigestWeekly, BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9}}
+	report := dispatcher.Run(webhooks, SnapshotEvaluator(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6}}, Currency{}))
+	if sent != 0 || report.Sent != 0 || !report.Results[0].WouldFire {
+		t.Error("Expected a digest-webhook to fire without being sent by the dispatcher, got ", report)
+	}
+}
+
+func Test_BuildDigestEmail(t *testing.T) {
+	digest := Digest{Kind: WebhookKindEmail, Period: DigestWeekly, Notifications: []Notification{
+		{Webhook: Webhook{BaseCurrency: "EUR", TargetCurrency: "SEK", MinTriggerValue: 9}, CurrentRate: 9.9, Date: "2017-11-20"},
+		{Webhook: Webhook{BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9}, CurrentRate: 9.6, Date: "2017-11-20"},
+	}}
+	subject, text, html, err := BuildDigestEmail(digest)
+	if err != nil {
+		t.Error("Error when building digest email: ", err)
+		return
+	}
+	if subject != "Weekly currency digest: 2 pairs inside their trigger-range" {
+		t.Error("Unexpected subject: " + subject)
+	}
+	if text != "Weekly currency digest\r\n\r\nEUR/NOK: 9.6 → (trigger-range ≥ 9, change No previous rate, 2017-11-20)\r\nEUR/SEK: 9.9 → (trigger-range ≥ 9, change No previous rate, 2017-11-20)\r\n" {
+		t.Error("Unexpected text: " + text)
+	}
+	if len(html) == 0 {
+		t.Error("Expected a html body")
+	}
+}
@@ -0,0 +1,307 @@
+package exchange
+
+import "errors"
+import "net/url"
+import "strings"
+import "sync"
+import "time"
+
+// Notification - This is the struct which holds a webhook and the rate it is evaluated against.
+type Notification struct {
+	Webhook      Webhook
+	CurrentRate  float32
+	Date         string
+	PreviousRate float32 // The rate in the snapshot before Date, 0 if we don't have one
+}
+
+// Evaluator decides if a webhook should be sent. It returns the notification to send, if it should be sent and why (or why not).
+type Evaluator func(webhook Webhook) (Notification, bool, string)
+
+// DeliveryResult - This is the struct which holds the outcome of evaluating (and maybe sending) one webhook.
+type DeliveryResult struct {
+	ID             string  `json:"id"`
+	WebhookURL     string  `json:"webhookurl"`
+	Email          string  `json:"email,omitempty"`
+	BaseCurrency   string  `json:"baseCurrency"`
+	TargetCurrency string  `json:"targetCurrency"`
+	CurrentRate    float32 `json:"currentRate"`
+	WouldFire      bool    `json:"wouldFire"`
+	Reason         string  `json:"reason"`
+	Sent           bool    `json:"sent"`
+	StatusCode     int     `json:"statusCode,omitempty"`
+	Error          string  `json:"error,omitempty"`
+	LatencyMs      int64   `json:"latencyMs"`
+}
+
+// DispatchReport - This is the struct which summarises a run of the dispatcher.
+type DispatchReport struct {
+	DryRun     bool             `json:"dryRun"`
+	Total      int              `json:"total"`
+	WouldFire  int              `json:"wouldFire"`
+	Sent       int              `json:"sent"`
+	Failed     int              `json:"failed"`
+	Skipped    int              `json:"skipped"`
+	DurationMs int64            `json:"durationMs"`
+	Results    []DeliveryResult `json:"results"`
+}
+
+// Dispatcher evaluates and sends webhooks with a bounded number of goroutines,
+// and at most PerHost requests at the same time to the same host.
+type Dispatcher struct {
+	Workers int
+	PerHost int
+	Send    func(Notification) (int, error) // SendWebhookFunc is used if not set
+}
+
+// Dispatch is the dispatcher used by the updater and the evaluation-trigger.
+var Dispatch = &Dispatcher{Workers: 20, PerHost: 4}
+
+// hostQueue hands out the webhooks to send, at most limit at the same time per host. The webhooks for a busy host wait
+// in its queue, so the workers send to the other hosts meanwhile instead of waiting for it.
+type hostQueue struct {
+	limit    int
+	mutex    sync.Mutex
+	free     *sync.Cond // Signalled when a send is done
+	hosts    []string   // The hosts with webhooks left, in the order they were first added
+	queues   map[string][]int
+	inFlight map[string]int
+}
+
+func newHostQueue(limit int) *hostQueue {
+	queue := &hostQueue{limit: limit, queues: map[string][]int{}, inFlight: map[string]int{}}
+	queue.free = sync.NewCond(&queue.mutex)
+	return queue
+}
+
+func (queue *hostQueue) add(host string, i int) {
+	if _, ok := queue.queues[host]; !ok {
+		queue.hosts = append(queue.hosts, host)
+	}
+	queue.queues[host] = append(queue.queues[host], i)
+}
+
+// next waits until a host with webhooks left has a free slot, and returns the host and the next webhook for it.
+// It returns false when there are no webhooks left.
+func (queue *hostQueue) next() (string, int, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for len(queue.hosts) > 0 {
+		for h, host := range queue.hosts {
+			if queue.inFlight[host] >= queue.limit {
+				continue
+			}
+			i := queue.queues[host][0]
+			queue.queues[host] = queue.queues[host][1:]
+			if len(queue.queues[host]) == 0 {
+				delete(queue.queues, host)
+				queue.hosts = append(queue.hosts[:h:h], queue.hosts[h+1:]...)
+			}
+			queue.inFlight[host]++
+			return host, i, true
+		}
+		queue.free.Wait()
+	}
+	return "", 0, false
+}
+
+// done frees the slot of a send to host.
+func (queue *hostQueue) done(host string) {
+	queue.mutex.Lock()
+	queue.inFlight[host]--
+	queue.mutex.Unlock()
+	queue.free.Broadcast()
+}
+
+func webhookHost(webhook Webhook) string {
+	if webhook.Kind == WebhookKindEmail {
+		return "email" // Every email goes through the same SMTP-server
+	}
+	parsedURL, err := url.Parse(webhook.WebhookURL)
+	if err != nil {
+		return webhook.WebhookURL
+	}
+	return strings.ToLower(parsedURL.Host)
+}
+
+// Run evaluates every webhook with evaluate, sends the ones that should be sent, and returns a report with one result per webhook (in the same order).
+// A failing webhook doesn't stop the others from being sent.
+func (dispatcher *Dispatcher) Run(webhooks []Webhook, evaluate Evaluator) DispatchReport {
+	return dispatcher.run(webhooks, evaluate, false)
+}
+
+// DryRun evaluates every webhook like Run, but doesn't send anything.
+func (dispatcher *Dispatcher) DryRun(webhooks []Webhook, evaluate Evaluator) DispatchReport {
+	return dispatcher.run(webhooks, evaluate, true)
+}
+
+func (dispatcher *Dispatcher) run(webhooks []Webhook, evaluate Evaluator, dryRun bool) DispatchReport {
+	start := time.Now()
+	send := dispatcher.Send
+	if send == nil {
+		send = func(notification Notification) (int, error) {
+			_, statusCode, err := DeliverWebhook(notification)
+			return statusCode, err
+		}
+	}
+	workers := dispatcher.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	perHost := dispatcher.PerHost
+	if perHost < 1 {
+		perHost = 1
+	}
+
+	// Every webhook is evaluated first, and the ones to send are queued by host
+	results := make([]DeliveryResult, len(webhooks))
+	notifications := make([]Notification, len(webhooks))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], notifications[i] = evaluateOne(webhooks[i], evaluate, dryRun)
+			}
+		}()
+	}
+	for i := range webhooks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	queue := newHostQueue(perHost)
+	for i, result := range results {
+		if result.Sent {
+			queue.add(webhookHost(webhooks[i]), i)
+		}
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				host, i, ok := queue.next()
+				if !ok {
+					return
+				}
+				sendOne(&results[i], notifications[i], send)
+				queue.done(host)
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := DispatchReport{DryRun: dryRun, Total: len(webhooks), Results: results}
+	for _, result := range results {
+		if !result.WouldFire {
+			report.Skipped++
+			continue
+		}
+		report.WouldFire++
+		if result.Sent && result.Error == "" {
+			report.Sent++
+		} else if result.Sent {
+			report.Failed++
+		}
+	}
+	report.DurationMs = int64(time.Since(start) / time.Millisecond)
+	return report
+}
+
+// evaluateOne evaluates webhook, and returns its result and the notification to send. Sent is set on the result if it should be sent.
+func evaluateOne(webhook Webhook, evaluate Evaluator, dryRun bool) (DeliveryResult, Notification) {
+	result := DeliveryResult{ID: webhook.ID, WebhookURL: webhook.WebhookURL, Email: webhook.Email, BaseCurrency: webhook.BaseCurrency, TargetCurrency: webhook.TargetCurrency}
+	notification, fire, reason := evaluate(webhook)
+	if !webhook.IsActive() {
+		fire, reason = false, "Webhook is "+webhook.State()
+	}
+	result.CurrentRate = notification.CurrentRate
+	result.Reason = reason
+	result.WouldFire = fire
+	if fire && webhook.Digest != "" {
+		result.Reason += ", it is sent in the " + webhook.Digest + " digest"
+		return result, notification // The updater collects it with a DigestCollector
+	}
+	result.Sent = fire && !dryRun
+	return result, notification
+}
+
+// sendOne sends notification, and records the outcome in result.
+func sendOne(result *DeliveryResult, notification Notification, send func(Notification) (int, error)) {
+	start := time.Now()
+	statusCode, err := send(notification)
+	result.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	result.StatusCode = statusCode
+	if err != nil {
+		result.Error = err.Error()
+	}
+}
+
+// SnapshotEvaluator fires the webhooks with the same base as currency, when the rate is inside their trigger-range.
+// previous is the snapshot before currency, and can be empty.
+func SnapshotEvaluator(currency Currency, previous Currency) Evaluator {
+	return func(webhook Webhook) (Notification, bool, string) {
+		if webhook.BaseCurrency != currency.Base {
+			return Notification{Webhook: webhook}, false, "Base-currency is not " + currency.Base
+		}
+		notification, err := NewNotification(webhook, []Currency{currency, previous})
+		if err != nil {
+			return notification, false, err.Error()
+		}
+		if !InTriggerRange(webhook, notification.CurrentRate) {
+			return notification, false, "Rate " + FloatToString(notification.CurrentRate) + " is outside the trigger-range"
+		}
+		return notification, true, "Rate " + FloatToString(notification.CurrentRate) + " is inside the trigger-range"
+	}
+}
+
+// LatestEvaluator fires the webhooks where the latest stored rate is inside their trigger-range. The latest currencies are only loaded once per base-currency.
+func LatestEvaluator(storage Storage) Evaluator {
+	var mutex sync.Mutex
+	latest := map[string][]Currency{}
+	errs := map[string]error{}
+	return func(webhook Webhook) (Notification, bool, string) {
+		mutex.Lock()
+		currencies, ok := latest[webhook.BaseCurrency]
+		err := errs[webhook.BaseCurrency]
+		mutex.Unlock()
+		if !ok && err == nil {
+			// Read without the lock, so the other bases aren't waiting for it. Workers with the same base may read it at the same time.
+			currencies, _, err = storage.GetCurrencies(webhook.BaseCurrency, 2)
+			mutex.Lock()
+			latest[webhook.BaseCurrency], errs[webhook.BaseCurrency] = currencies, err
+			mutex.Unlock()
+		}
+		if err != nil {
+			return Notification{Webhook: webhook}, false, "Couldn't get latest currency: " + err.Error()
+		}
+		notification, err := NewNotification(webhook, currencies)
+		if err != nil {
+			return notification, false, err.Error()
+		}
+		if !InTriggerRange(webhook, notification.CurrentRate) {
+			return notification, false, "Rate " + FloatToString(notification.CurrentRate) + " from " + notification.Date + " is outside the trigger-range"
+		}
+		return notification, true, "Rate " + FloatToString(notification.CurrentRate) + " from " + notification.Date + " is inside the trigger-range"
+	}
+}
+
+// NewNotification makes a notification for webhook from currencies, where currencies[0] is the latest snapshot and currencies[1] (if any) the one before.
+func NewNotification(webhook Webhook, currencies []Currency) (Notification, error) {
+	notification := Notification{Webhook: webhook}
+	if len(currencies) == 0 {
+		return notification, errors.New("No stored currencies with base " + webhook.BaseCurrency)
+	}
+	rate, ok := currencies[0].Rates[webhook.TargetCurrency]
+	if !ok {
+		return notification, errors.New("No rate for " + webhook.TargetCurrency)
+	}
+	notification.CurrentRate, notification.Date = rate, currencies[0].Date
+	if len(currencies) > 1 {
+		notification.PreviousRate = currencies[1].Rates[webhook.TargetCurrency]
+	}
+	return notification, nil
+}
@@ -0,0 +1,111 @@
+package exchange
+
+import "testing"
+import "errors"
+import "strconv"
+import "sync"
+import "time"
+
+func Test_Dispatcher_Run(t *testing.T) {
+	webhooks := []Webhook{}
+	for i := 0; i < 40; i++ {
+		webhooks = append(webhooks, Webhook{ID: strconv.Itoa(i), WebhookURL: "http://host" + strconv.Itoa(i%2) + ".example/", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: float32(i % 4)})
+	}
+	currency := Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 2.5}}
+
+	var mutex sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+	dispatcher := &Dispatcher{Workers: 8, PerHost: 2, Send: func(notification Notification) (int, error) {
+		host := webhookHost(notification.Webhook)
+		mutex.Lock()
+		running[host]++
+		if running[host] > maxRunning[host] {
+			maxRunning[host] = running[host]
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		running[host]--
+		mutex.Unlock()
+		if notification.Webhook.ID == "1" {
+			return 400, errors.New("receiver failed")
+		}
+		return 200, nil
+	}}
+
+	report := dispatcher.Run(webhooks, SnapshotEvaluator(currency, Currency{}))
+	if report.Total != 40 || report.WouldFire != 30 || report.Sent != 29 || report.Failed != 1 || report.Skipped != 10 { // Min 3 is above the rate for every fourth webhook
+		t.Error("Unexpected report: ", report.Total, report.Sent, report.Failed, report.Skipped)
+	}
+	for i, result := range report.Results {
+		if result.ID != webhooks[i].ID {
+			t.Error("Results are not in the same order as the webhooks")
+			break
+		}
+	}
+	for host, max := range maxRunning {
+		if max > 2 {
+			t.Error("Expected at most 2 requests at the same time to "+host+", but had ", max)
+		}
+	}
+}
+
+func Test_Dispatcher_BusyHost(t *testing.T) {
+	webhooks := []Webhook{}
+	for i := 0; i < 10; i++ {
+		webhooks = append(webhooks, Webhook{ID: strconv.Itoa(i), WebhookURL: "http://slow.example/", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1})
+	}
+	webhooks = append(webhooks, Webhook{ID: "other", WebhookURL: "http://other.example/", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1})
+	currency := Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 2.5}}
+
+	release := make(chan bool)
+	otherSent := make(chan bool)
+	dispatcher := &Dispatcher{Workers: 4, PerHost: 1, Send: func(notification Notification) (int, error) {
+		if notification.Webhook.ID == "other" {
+			close(otherSent)
+		} else {
+			<-release
+		}
+		return 200, nil
+	}}
+	done := make(chan DispatchReport)
+	go func() { done <- dispatcher.Run(webhooks, SnapshotEvaluator(currency, Currency{})) }()
+	select {
+	case <-otherSent:
+	case <-time.After(time.Second):
+		t.Error("Expected the other host to be sent to while the slow host is busy")
+	}
+	close(release)
+	if report := <-done; report.Sent != 11 {
+		t.Error("Expected every webhook to be sent, but sent ", report.Sent)
+	}
+}
+
+func Test_Dispatcher_DryRun(t *testing.T) {
+	db := &memoryStorage{}
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6}})
+	webhooks := []Webhook{
+		{ID: "inside", WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, MaxTriggerValue: 10},
+		{ID: "outside", WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 10},
+		{ID: "unknown", WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "XYZ", MinTriggerValue: 1},
+		{ID: "nobase", WebhookURL: "http://example.com", BaseCurrency: "USD", TargetCurrency: "NOK", MinTriggerValue: 1},
+	}
+	dispatcher := &Dispatcher{Workers: 2, PerHost: 1, Send: func(notification Notification) (int, error) {
+		t.Error("Nothing should be sent in a dry-run")
+		return 200, nil
+	}}
+
+	report := dispatcher.DryRun(webhooks, LatestEvaluator(db))
+	if !report.DryRun || report.WouldFire != 1 || report.Skipped != 3 || report.Sent != 0 {
+		t.Error("Unexpected report: ", report)
+	}
+	if !report.Results[0].WouldFire || report.Results[0].CurrentRate != 9.6 || report.Results[0].Sent {
+		t.Error("Expected the first webhook to be reported as would fire with rate 9.6, got ", report.Results[0])
+	}
+	for _, result := range report.Results {
+		if result.Reason == "" {
+			t.Error("Expected a reason for webhook " + result.ID)
+		}
+	}
+}
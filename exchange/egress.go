@@ -0,0 +1,231 @@
+package exchange
+
+import "context"
+import "errors"
+import "net"
+import "net/url"
+import "os"
+import "strings"
+import "time"
+
+// Resolver - The part of net.Resolver the egress-policy needs, so lookups can be faked in tests.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// EgressPolicy decides which hosts and addresses we are allowed to send webhooks to.
+// Allow-entries wins over deny-entries, and everything not denied is allowed.
+type EgressPolicy struct {
+	AllowCIDRs []*net.IPNet
+	DenyCIDRs  []*net.IPNet
+	AllowHosts []string // Hostnames, or ".example.com" to match all subdomains of example.com
+	DenyHosts  []string
+	Resolver   Resolver
+	Dialer     *net.Dialer
+}
+
+// Loopback, private, link-local (cloud metadata lives on 169.254.169.254) and other non-public ranges.
+var defaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+var defaultDeniedHosts = []string{"localhost", ".localhost", ".internal", ".local"}
+
+// Egress is the policy used when registering and sending webhooks.
+var Egress = DefaultEgressPolicy()
+
+// DefaultEgressPolicy returns a policy which denies everything that is not a public address.
+func DefaultEgressPolicy() *EgressPolicy {
+	policy, err := NewEgressPolicy(nil, nil)
+	if err != nil {
+		panic(err) // The default lists are hardcoded, so this is a programming error.
+	}
+	return policy
+}
+
+// NewEgressPolicy makes a policy from allow- and deny-lists in addition to the default deny-list.
+// Every entry is either a CIDR (10.0.0.0/8), an ip-address or a hostname (example.com, or .example.com for subdomains).
+func NewEgressPolicy(allow []string, deny []string) (*EgressPolicy, error) {
+	policy := &EgressPolicy{Resolver: net.DefaultResolver, Dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}}
+	for _, entry := range allow {
+		if err := policy.add(entry, &policy.AllowCIDRs, &policy.AllowHosts); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range append(append(defaultDeniedCIDRs, defaultDeniedHosts...), deny...) {
+		if err := policy.add(entry, &policy.DenyCIDRs, &policy.DenyHosts); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// EgressConfig - The allow- and deny-lists of the egress-policy, the egress-section of Config. See NewEgressPolicy.
+type EgressConfig struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+// EgressConfigFromEnv overrides the lists in config with the comma-separated environment-variables EGRESS_ALLOW and EGRESS_DENY, where they are set.
+func EgressConfigFromEnv(config EgressConfig) EgressConfig {
+	if value := os.Getenv("EGRESS_ALLOW"); value != "" {
+		config.Allow = strings.Split(value, ",")
+	}
+	if value := os.Getenv("EGRESS_DENY"); value != "" {
+		config.Deny = strings.Split(value, ",")
+	}
+	return config
+}
+
+// Policy makes the egress-policy of the config.
+func (config EgressConfig) Policy() (*EgressPolicy, error) {
+	return NewEgressPolicy(config.Allow, config.Deny)
+}
+
+func (policy *EgressPolicy) add(entry string, cidrs *[]*net.IPNet, hosts *[]string) error {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if entry == "" {
+		return nil
+	}
+	if strings.Contains(entry, "/") {
+		_, cidr, err := net.ParseCIDR(entry)
+		if err != nil {
+			return errors.New("Egress-policy: " + entry + " is not a valid CIDR")
+		}
+		*cidrs = append(*cidrs, cidr)
+	} else if ip := net.ParseIP(entry); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		*cidrs = append(*cidrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	} else {
+		*hosts = append(*hosts, strings.TrimPrefix(entry, "*"))
+	}
+	return nil
+}
+
+func hostMatches(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if host == pattern || (strings.HasPrefix(pattern, ".") && (strings.HasSuffix(host, pattern) || host == pattern[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipMatches(ip net.IP, cidrs []*net.IPNet) bool {
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost returns allowed=true if the hostname is explicitly allowed (no need to check the addresses),
+// and an error if it is explicitly denied.
+func (policy *EgressPolicy) checkHost(host string) (bool, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if hostMatches(host, policy.AllowHosts) {
+		return true, nil
+	}
+	if hostMatches(host, policy.DenyHosts) {
+		return false, errors.New("Host " + host + " is not an allowed destination")
+	}
+	return false, nil
+}
+
+// CheckIP returns an error if we are not allowed to connect to ip.
+func (policy *EgressPolicy) CheckIP(ip net.IP) error {
+	if ipMatches(ip, policy.AllowCIDRs) {
+		return nil
+	}
+	if ipMatches(ip, policy.DenyCIDRs) {
+		return errors.New("Address " + ip.String() + " is not an allowed destination")
+	}
+	return nil
+}
+
+// resolve looks up host and returns the addresses we are allowed to connect to, or an error if any of them are denied.
+func (policy *EgressPolicy) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	allowed, err := policy.checkHost(host)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if allowed {
+			return []net.IP{ip}, nil
+		}
+		return []net.IP{ip}, policy.CheckIP(ip)
+	}
+	addrs, err := policy.Resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("Host " + host + " has no addresses")
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+		if allowed {
+			continue
+		}
+		if err := policy.CheckIP(addr.IP); err != nil { // If one of the addresses is denied, deny all, so we don't depend on which one we get.
+			return nil, errors.New("Host " + host + " resolves to a denied address: " + err.Error())
+		}
+	}
+	return ips, nil
+}
+
+// CheckURL returns an error if we are not allowed to send requests to rawURL.
+func (policy *EgressPolicy) CheckURL(rawURL string) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if parsedURL.Hostname() == "" {
+		return errors.New("Url " + rawURL + " has no host")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = policy.resolve(ctx, parsedURL.Hostname())
+	return err
+}
+
+// DialContext can be used as http.Transport.DialContext. The host is resolved and checked here,
+// and we connect to the checked address, so DNS-rebinding can't give us another address afterwards.
+func (policy *EgressPolicy) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := policy.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	for _, ip := range ips {
+		conn, err = policy.Dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
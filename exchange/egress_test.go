@@ -0,0 +1,95 @@
+package exchange
+
+import "testing"
+import "context"
+import "errors"
+import "net"
+import "net/http"
+import "net/http/httptest"
+
+// fakeResolver answers lookups from a map, so the tests don't need DNS.
+type fakeResolver map[string][]string
+
+func (resolver fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := resolver[host]
+	if !ok {
+		return nil, errors.New("no such host " + host)
+	}
+	addrs := []net.IPAddr{}
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+var testResolver = fakeResolver{
+	"example.com":      {"93.184.216.34"},
+	"hooks.slack.com":  {"54.192.1.1"},
+	"discord.com":      {"162.159.128.233"},
+	"metadata.example": {"169.254.169.254"},
+	"mixed.example":    {"93.184.216.34", "10.0.0.1"},
+	"rebind.example":   {"127.0.0.1"},
+}
+
+func setupTestEgress(allow []string, deny []string) *EgressPolicy {
+	policy, err := NewEgressPolicy(allow, deny)
+	if err != nil {
+		panic(err)
+	}
+	policy.Resolver = testResolver
+	return policy
+}
+
+func Test_EgressPolicy_CheckURL(t *testing.T) {
+	policy := setupTestEgress([]string{"192.168.10.0/24"}, []string{"evil.example"})
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://example.com/hook", true},
+		{"http://93.184.216.34:8080/", true},
+		{"http://127.0.0.1/", false},
+		{"http://[::1]/", false},
+		{"http://localhost:8080/", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://metadata.example/", false},
+		{"http://mixed.example/", false},
+		{"http://10.1.2.3/", false},
+		{"http://192.168.10.5/", true}, // Explicitly allowed, even if 192.168.0.0/16 is denied
+		{"http://192.168.11.5/", false},
+		{"http://evil.example/", false},
+		{"http://unknown.example/", false},
+	}
+	for _, test := range tests {
+		err := policy.CheckURL(test.url)
+		if test.allowed && err != nil {
+			t.Error("Expected "+test.url+" to be allowed, but got error: ", err)
+		}
+		if !test.allowed && err == nil {
+			t.Error("Expected " + test.url + " to be denied")
+		}
+	}
+}
+
+func Test_EgressPolicy_DialContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// rebind.example resolves to 127.0.0.1, which we deny by default, even if the url was ok'ed before.
+	policy := setupTestEgress(nil, nil)
+	client := &http.Client{Transport: &http.Transport{DialContext: policy.DialContext}}
+	_, err := client.Get("http://" + net.JoinHostPort("rebind.example", port))
+	if err == nil {
+		t.Error("Expected connection to loopback to be denied when dialing")
+	}
+
+	policy = setupTestEgress([]string{"127.0.0.1"}, nil)
+	client = &http.Client{Transport: &http.Transport{DialContext: policy.DialContext}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Error("Expected connection to explicitly allowed address to work, got error: ", err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,146 @@
+package exchange
+
+import "bytes"
+import "errors"
+import "fmt"
+import htmltemplate "html/template"
+import "mime"
+import "mime/multipart"
+import "mime/quotedprintable"
+import "net"
+import "net/http"
+import "net/mail"
+import "net/smtp"
+import "net/textproto"
+import "os"
+import "strconv"
+import "strings"
+import "time"
+
+// WebhookKindEmail sends the notification as an email to Webhook.Email instead of posting to a url.
+const WebhookKindEmail = "email"
+
+// SMTPConfig - Settings for the SMTP-server emails are sent through.
+type SMTPConfig struct {
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	Username string `json:"username" yaml:"username"` // No authentication if empty
+	Password string `json:"password" yaml:"password"`
+	From     string `json:"from" yaml:"from"`
+}
+
+// Mailer sends emails through a SMTP-server.
+type Mailer struct {
+	Config SMTPConfig
+}
+
+// Mail is the mailer used for email-notifications, nil if no SMTP-server is configured.
+var Mail *Mailer
+
+// SMTPConfigFromEnv reads SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM.
+// ok is false if SMTP_HOST isn't set, ie. email is not configured.
+func SMTPConfigFromEnv() (config SMTPConfig, ok bool, err error) {
+	config = SMTPConfig{Host: os.Getenv("SMTP_HOST"), Port: 587, Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD"), From: os.Getenv("SMTP_FROM")}
+	if config.Host == "" {
+		return config, false, nil
+	}
+	if value := os.Getenv("SMTP_PORT"); value != "" {
+		if config.Port, err = strconv.Atoi(value); err != nil {
+			return config, false, errors.New("SMTP_PORT: " + err.Error())
+		}
+	}
+	if _, err = mail.ParseAddress(config.From); err != nil {
+		return config, false, errors.New("SMTP_FROM: " + err.Error())
+	}
+	return config, true, nil
+}
+
+// NewMailer makes a mailer which sends through the SMTP-server in config.
+func NewMailer(config SMTPConfig) *Mailer {
+	return &Mailer{Config: config}
+}
+
+// Send sends an email with both a plain-text and a html body.
+func (mailer *Mailer) Send(to []string, subject string, text string, html string) error {
+	message, err := mailer.buildMessage(to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if mailer.Config.Username != "" {
+		auth = smtp.PlainAuth("", mailer.Config.Username, mailer.Config.Password, mailer.Config.Host)
+	}
+	from, err := mail.ParseAddress(mailer.Config.From)
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(mailer.Config.Host, strconv.Itoa(mailer.Config.Port))
+	return smtp.SendMail(address, auth, from.Address, to, message)
+}
+
+// buildMessage makes a multipart/alternative email, so email-clients can choose between the text and the html.
+func (mailer *Mailer) buildMessage(to []string, subject string, text string, html string) ([]byte, error) {
+	message := new(bytes.Buffer)
+	parts := new(bytes.Buffer)
+	writer := multipart.NewWriter(parts)
+	for _, body := range []struct{ contentType, content string }{{"text/plain; charset=utf-8", text}, {"text/html; charset=utf-8", html}} {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {body.contentType}, "Content-Transfer-Encoding": {"quoted-printable"}})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(part)
+		encoder.Write([]byte(body.content))
+		encoder.Close()
+	}
+	writer.Close()
+
+	fmt.Fprintf(message, "From: %s\r\n", mailer.Config.From)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	message.Write(parts.Bytes())
+	return message.Bytes(), nil
+}
+
+var emailHTML = htmltemplate.Must(htmltemplate.New("email").Parse(`<html><body style="font-family: sans-serif">
+<h2>{{.Summary}}</h2>
+<table>
+<tr><td>Rate</td><td><b>{{.Rate}}</b></td></tr>
+<tr><td>Trigger-range</td><td>{{.Band}}</td></tr>
+<tr><td>Change</td><td>{{.Change}}</td></tr>
+<tr><td>Date</td><td>{{.Date}}</td></tr>
+</table>
+</body></html>`))
+
+// BuildEmail renders a notification as the subject, plain-text and html body of an email.
+func BuildEmail(notification Notification) (string, string, string, error) {
+	data := NewPayloadData(notification)
+	fields := struct{ Summary, Rate, Band, Change, Date string }{chatSummary(data), FloatToString(data.CurrentRate) + " " + trendArrow(data), triggerBand(data), changeText(data), data.Date}
+	subject := "Currency alert: " + fields.Summary
+	text := fields.Summary + "\r\n\r\nRate: " + fields.Rate + "\r\nTrigger-range: " + fields.Band + "\r\nChange: " + fields.Change + "\r\nDate: " + fields.Date + "\r\n"
+	html := new(bytes.Buffer)
+	if err := emailHTML.Execute(html, fields); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, html.String(), nil
+}
+
+// deliverEmail sends the notification to the email-address of the webhook.
+func deliverEmail(notification Notification) (DeliveryResponse, int, error) {
+	if Mail == nil {
+		return DeliveryResponse{}, http.StatusInternalServerError, errors.New("Email is not configured on this server (SMTP_HOST is not set)")
+	}
+	subject, text, html, err := BuildEmail(notification)
+	if err != nil {
+		return DeliveryResponse{}, http.StatusInternalServerError, err
+	}
+	start := time.Now()
+	err = Mail.Send([]string{notification.Webhook.Email}, subject, text, html)
+	response := DeliveryResponse{Latency: time.Since(start)}
+	if err != nil {
+		return response, http.StatusExpectationFailed, err
+	}
+	return response, http.StatusOK, nil
+}
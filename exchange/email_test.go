@@ -0,0 +1,159 @@
+package exchange
+
+import "testing"
+import "bufio"
+import "encoding/json"
+import "net"
+import "net/http"
+import "net/http/httptest"
+import "strconv"
+import "strings"
+
+// fakeSMTPServer accepts emails on a local port and sends the DATA of each email on the returned channel.
+func fakeSMTPServer(t *testing.T) (SMTPConfig, chan string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Couldn't start the fake SMTP-server: ", err)
+	}
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeSMTP(conn, messages)
+		}
+	}()
+	port, _ := strconv.Atoi(strings.Split(listener.Addr().String(), ":")[1])
+	config := SMTPConfig{Host: "127.0.0.1", Port: port, From: "Currency alerts <alerts@example.com>"}
+	return config, messages, func() { listener.Close() }
+}
+
+func serveFakeSMTP(conn net.Conn, messages chan string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("220 fake SMTP\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "DATA"):
+			conn.Write([]byte("354 Go ahead\r\n"))
+			data := ""
+			for !strings.HasSuffix(data, "\r\n.\r\n") {
+				part, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				data += part
+			}
+			messages <- data
+			conn.Write([]byte("250 OK\r\n"))
+		case strings.HasPrefix(command, "QUIT"):
+			conn.Write([]byte("221 Bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
+
+func Test_Mailer_Send(t *testing.T) {
+	config, messages, stop := fakeSMTPServer(t)
+	defer stop()
+
+	err := NewMailer(config).Send([]string{"user@example.com"}, "EUR/NOK is 9.5 ↑", "The text", "<p>The html</p>")
+	if err != nil {
+		t.Error("Error when sending email: ", err)
+		return
+	}
+	message := <-messages
+	for _, expected := range []string{"To: user@example.com", "Subject: =?utf-8?q?", "multipart/alternative", "text/plain; charset=utf-8", "The text", "text/html; charset=utf-8", "<p>The html</p>"} {
+		if !strings.Contains(message, expected) {
+			t.Error("Expected the email to contain " + expected + ", but got: " + message)
+		}
+	}
+}
+
+func Test_DeliverWebhook_Email(t *testing.T) {
+	config, messages, stop := fakeSMTPServer(t)
+	defer stop()
+	Mail = NewMailer(config)
+	defer func() { Mail = nil }()
+
+	webhook := Webhook{Kind: WebhookKindEmail, Email: "user@example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, MaxTriggerValue: 10}
+	_, statusCode, err := DeliverWebhook(Notification{Webhook: webhook, CurrentRate: 9.5, PreviousRate: 9.4, Date: "2017-11-20"})
+	if err != nil || statusCode != http.StatusOK {
+		t.Error("Expected the email to be delivered, but got ", statusCode, ": ", err)
+		return
+	}
+	message := <-messages
+	if !strings.Contains(message, "Trigger-range: 9 =E2=80=93 10") || !strings.Contains(message, "Date: 2017-11-20") {
+		t.Error("Expected the email to contain the trigger-range and the date, but got: " + message)
+	}
+
+	Mail = nil
+	if _, statusCode, err = DeliverWebhook(Notification{Webhook: webhook, CurrentRate: 9.5}); err == nil || statusCode != http.StatusInternalServerError {
+		t.Error("Expected 500 when email is not configured, but got ", statusCode)
+	}
+}
+
+func Test_Handler_RegisterEmailWebhook(t *testing.T) {
+	config, messages, stop := fakeSMTPServer(t)
+	defer stop()
+	Mail = NewMailer(config)
+	defer func() { Mail = nil }()
+	DB = setupValidationDatabase()
+
+	body := `{"kind": "email", "email": "user@example.com", "baseCurrency": "EUR", "targetCurrency": "NOK", "minTriggerValue": 9}`
+	w := httptest.NewRecorder()
+	RegisterWebhook(w, httptest.NewRequest("POST", "/exchange", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Error("Expected statuscode 201 when registering an email webhook, got ", w.Code, ": ", w.Body.String())
+		return
+	}
+	created := Webhook{}
+	json.NewDecoder(w.Body).Decode(&created)
+	id := created.ID
+	if created.Status != WebhookPending {
+		t.Error("Expected the registered webhook to be answered as pending, but it is " + created.Status)
+	}
+	webhook, _, _ := DB.GetWebhook(id)
+	if webhook.Status != WebhookPending {
+		t.Error("Expected the email webhook to be pending until the challenge is posted, but it is " + webhook.Status)
+	}
+	if message := <-messages; !strings.Contains(message, webhook.Challenge) {
+		t.Error("Expected the verification email to contain the challenge, but got: " + message)
+	}
+
+	w = httptest.NewRecorder()
+	VerifyWebhookHandler(w, httptest.NewRequest("POST", "/exchange/"+id+"/verify", strings.NewReader(`{"challenge": "wrong"}`)), id)
+	if w.Code != http.StatusConflict {
+		t.Error("Expected statuscode 409 for a wrong challenge, got ", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	VerifyWebhookHandler(w, httptest.NewRequest("POST", "/exchange/"+id+"/verify", strings.NewReader(`{"challenge": "`+webhook.Challenge+`"}`)), id)
+	if w.Code != http.StatusOK {
+		t.Error("Expected statuscode 200 for the right challenge, got ", w.Code, ": ", w.Body.String())
+	}
+	if webhook, _, _ = DB.GetWebhook(id); webhook.Status != WebhookActive {
+		t.Error("Expected the email webhook to be active after posting the challenge, but it is " + webhook.Status)
+	}
+}
+
+func Test_ValidateWebhook_Email(t *testing.T) {
+	DB = setupValidationDatabase()
+	webhook := Webhook{Kind: WebhookKindEmail, Email: "not an address", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, PayloadTemplate: "{}"}
+	errs, _, err := ValidateWebhook(webhook, DB)
+	if err != nil {
+		t.Error("Error when validating: ", err)
+	}
+	if !hasFieldError(errs, "email") || !hasFieldError(errs, "kind") || hasFieldError(errs, "webhookurl") {
+		t.Error("Expected errors for email and kind, but not for the missing webhookurl, got ", errs)
+	}
+}
@@ -0,0 +1,263 @@
+package exchange
+
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Event is something that happened in the updater. Subscribers switch on the type to get the details.
+type Event interface {
+	EventName() string
+}
+
+// FetchStarted - The updater starts to get the rates for Base.
+type FetchStarted struct {
+	Base string
+	Date string // "latest" or the date asked for
+}
+
+// FetchFailed - The rates for Base couldn't be fetched, stored or evaluated.
+type FetchFailed struct {
+	Base   string
+	Date   string
+	Reason string
+}
+
+// StorageFailed - The storage couldn't be read or written while doing Operation, like "get all webhooks".
+type StorageFailed struct {
+	Operation  string
+	StatusCode int
+	Reason     string
+}
+
+// SnapshotStored - A new snapshot of the rates was stored.
+type SnapshotStored struct {
+	Currency Currency
+}
+
+// SnapshotDuplicate - The fetched snapshot has the same date as the latest stored one, so nothing was stored.
+type SnapshotDuplicate struct {
+	Currency   Currency
+	LatestDate string
+}
+
+// WebhookDelivered - A webhook was sent, and the receiver accepted it.
+type WebhookDelivered struct {
+	Webhook Webhook
+	Result  DeliveryResult
+}
+
+// WebhookFailed - A webhook was sent, but the delivery failed.
+type WebhookFailed struct {
+	Webhook Webhook
+	Result  DeliveryResult
+}
+
+// VerificationFailed - A new webhook couldn't be verified when it was registered, and is pending until it is verified again.
+type VerificationFailed struct {
+	Webhook Webhook
+	Reason  string
+}
+
+// WebhookAutoDisabled - A webhook failed too many deliveries in a row, and was disabled until it is resumed.
+type WebhookAutoDisabled struct {
+	Webhook Webhook
+}
+
+// DispatchFinished - Every webhook was evaluated against a new snapshot of Base.
+type DispatchFinished struct {
+	Base   string
+	Report DispatchReport
+}
+
+// DigestFailed - A digest couldn't be sent.
+type DigestFailed struct {
+	Result DigestResult
+}
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event FetchStarted) EventName() string { return "FetchStarted" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event FetchFailed) EventName() string { return "FetchFailed" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event StorageFailed) EventName() string { return "StorageFailed" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event SnapshotStored) EventName() string { return "SnapshotStored" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event SnapshotDuplicate) EventName() string { return "SnapshotDuplicate" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event WebhookDelivered) EventName() string { return "WebhookDelivered" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event WebhookFailed) EventName() string { return "WebhookFailed" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event VerificationFailed) EventName() string { return "VerificationFailed" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event WebhookAutoDisabled) EventName() string { return "WebhookAutoDisabled" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event DispatchFinished) EventName() string { return "DispatchFinished" }
+
+// EventName returns the name of the event, used in logs and metrics.
+func (event DigestFailed) EventName() string { return "DigestFailed" }
+
+// EventBus sends every published event to every subscriber, in the order they subscribed.
+type EventBus struct {
+	mutex       sync.RWMutex
+	subscribers []func(Event)
+}
+
+// NewEventBus makes a bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Events is the bus the updater publishes to.
+var Events = NewEventBus()
+
+// Subscribe makes handler get every event published after this.
+func (bus *EventBus) Subscribe(handler func(Event)) {
+	bus.mutex.Lock()
+	bus.subscribers = append(bus.subscribers, handler)
+	bus.mutex.Unlock()
+}
+
+// Publish sends event to the subscribers. It returns when every subscriber has handled it.
+func (bus *EventBus) Publish(event Event) {
+	bus.mutex.RLock()
+	subscribers := bus.subscribers
+	bus.mutex.RUnlock()
+	for _, handler := range subscribers {
+		handler(event)
+	}
+}
+
+// PublishDeliveries publishes WebhookDelivered or WebhookFailed for every webhook that was sent in report, and DispatchFinished for the run.
+func PublishDeliveries(bus *EventBus, base string, webhooks []Webhook, report DispatchReport) {
+	for i, result := range report.Results {
+		if !result.Sent {
+			continue
+		}
+		if result.Error == "" {
+			bus.Publish(WebhookDelivered{webhooks[i], result})
+		} else {
+			bus.Publish(WebhookFailed{webhooks[i], result})
+		}
+	}
+	bus.Publish(DispatchFinished{base, report})
+}
+
+// LogSubscriber prints the events.
+func LogSubscriber(event Event) {
+	switch e := event.(type) {
+	case FetchStarted:
+		println("Getting currencies with base " + e.Base + " (" + e.Date + ")")
+	case FetchFailed:
+		println(e.Reason)
+	case StorageFailed:
+		println("Couldn't " + e.Operation + ", got statuscode " + strconv.Itoa(e.StatusCode) + ", with error: " + e.Reason)
+	case SnapshotStored:
+		println("Registered new currency to database with base " + e.Currency.Base + " and the date " + e.Currency.Date + " (" + strconv.Itoa(len(e.Currency.Rates)) + " rates)")
+	case SnapshotDuplicate:
+		println("No new currencies with base " + e.Currency.Base + ", the latest is still from " + e.LatestDate)
+	case WebhookFailed:
+		println("Failed to send webhook " + e.Webhook.ID + ": " + e.Result.Error)
+	case VerificationFailed:
+		println("Webhook " + e.Webhook.ID + " is pending verification: " + e.Reason)
+	case WebhookAutoDisabled:
+		println("Disabled webhook " + e.Webhook.ID + " after " + strconv.Itoa(e.Webhook.Failures) + " failed deliveries in a row")
+	case DispatchFinished:
+		println("Sent " + strconv.Itoa(e.Report.Sent) + " of " + strconv.Itoa(e.Report.Total) + " webhooks in " + strconv.FormatInt(e.Report.DurationMs, 10) + "ms, " + strconv.Itoa(e.Report.Failed) + " failed")
+	case DigestFailed:
+		println("Failed to send " + e.Result.Period + " digest to " + e.Result.Destination + ": " + e.Result.Error)
+	}
+}
+
+// AlertSubscriber returns a subscriber which queues the events the operator should know about on Alerts, as a MessageWebhook from fromService.
+func AlertSubscriber(fromService string) func(Event) {
+	return func(event Event) {
+		msg := MessageWebhook{DateTime: time.Now().Format("2006-01-02-15:04:05"), FromService: fromService}
+		severity := SeverityInfo
+		switch e := event.(type) {
+		case FetchFailed:
+			severity, msg.Heading, msg.Message = SeverityError, "Couldn't save the latest currency to database!", e.Reason
+		case StorageFailed:
+			severity, msg.Heading = SeverityError, "The database failed!"
+			msg.Message = "Couldn't " + e.Operation + ", got statuscode " + strconv.Itoa(e.StatusCode) + ", with error: " + e.Reason
+		case SnapshotStored:
+			msg.Heading, msg.Message = "Registered new currency!", "Registered new currency to database with the date "+e.Currency.Date
+		case SnapshotDuplicate:
+			msg.Heading = "No new currencies!"
+			msg.Message = "Checked for new currencies, but there was no new currencies to register to database, tried to register currency with date " + e.Currency.Date + ", which is the same as latest currency (which also has the date " + e.LatestDate
+		case DispatchFinished:
+			if e.Report.Failed == 0 {
+				return
+			}
+			failed := []string{}
+			for _, result := range e.Report.Results {
+				if result.Sent && result.Error != "" {
+					failed = append(failed, result.ID+" ("+result.Error+")")
+				}
+			}
+			severity, msg.Heading = SeverityWarning, "Failed to send webhooks!"
+			msg.Message = "Failed to send " + strconv.Itoa(e.Report.Failed) + " of " + strconv.Itoa(e.Report.Sent+e.Report.Failed) + " webhooks for base " + e.Base + ": " + strings.Join(failed, ", ")
+		case WebhookAutoDisabled:
+			severity, msg.Heading = SeverityWarning, "Disabled a webhook!"
+			msg.Message = "Disabled webhook " + e.Webhook.ID + " (" + e.Webhook.BaseCurrency + "/" + e.Webhook.TargetCurrency + ") after " + strconv.Itoa(e.Webhook.Failures) + " failed deliveries in a row"
+		case DigestFailed:
+			severity, msg.Heading = SeverityWarning, "Failed to send digest!"
+			msg.Message = "Failed to send the " + e.Result.Period + " digest to " + e.Result.Destination + ": " + e.Result.Error
+		default:
+			return
+		}
+		Alerts.Queue(severity, msg) // Sent in the background, so a sink which is down doesn't hold up the publisher
+	}
+}
+
+// EventCounter is a subscriber which counts the events by name, as simple metrics.
+type EventCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// NewEventCounter makes a counter where every count is 0.
+func NewEventCounter() *EventCounter {
+	return &EventCounter{counts: map[string]int{}}
+}
+
+// Handle counts event. Subscribe with bus.Subscribe(counter.Handle).
+func (counter *EventCounter) Handle(event Event) {
+	counter.mutex.Lock()
+	counter.counts[event.EventName()]++
+	counter.mutex.Unlock()
+}
+
+// Counts returns a copy of the counts.
+func (counter *EventCounter) Counts() map[string]int {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counts := map[string]int{}
+	for name, count := range counter.counts {
+		counts[name] = count
+	}
+	return counts
+}
+
+// DeliveryLogSubscriber returns a subscriber which records every delivery on the webhook in storage, like RecordDeliveries.
+func DeliveryLogSubscriber(storage Storage) func(Event) {
+	return func(event Event) {
+		switch e := event.(type) {
+		case WebhookDelivered:
+			recordDelivery(storage, e.Webhook, false, "")
+		case WebhookFailed:
+			recordDelivery(storage, e.Webhook, true, "")
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package exchange
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+
+func Test_EventBus_Publish(t *testing.T) {
+	bus := NewEventBus()
+	order := []string{}
+	bus.Subscribe(func(event Event) { order = append(order, "first "+event.EventName()) })
+	bus.Subscribe(func(event Event) { order = append(order, "second "+event.EventName()) })
+	bus.Publish(FetchStarted{Base: "EUR", Date: "latest"})
+	if len(order) != 2 || order[0] != "first FetchStarted" || order[1] != "second FetchStarted" {
+		t.Error("Expected both subscribers to get the event in order, got ", order)
+	}
+}
+
+func Test_PublishDeliveries(t *testing.T) {
+	defer func(delivery *DeliveryClient) { Delivery = delivery }(Delivery)
+	config := DefaultDeliveryConfig()
+	config.MaxConsecutiveFailures = 1
+	Delivery = setupTestDelivery(config)
+	db := &memoryStorage{}
+	okID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://ok.example", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1, Failures: 2})
+	failingID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://failing.example", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1})
+	db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://outside.example", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 100})
+	webhooks, _, _ := db.GetAllWebhooks()
+	report := DispatchReport{Results: []DeliveryResult{{ID: okID, Sent: true, WouldFire: true}, {ID: failingID, Sent: true, WouldFire: true, Error: "receiver failed"}, {WouldFire: false}}, Sent: 1, Failed: 1}
+
+	bus := NewEventBus()
+	counter := NewEventCounter()
+	bus.Subscribe(counter.Handle)
+	bus.Subscribe(DeliveryLogSubscriber(db))
+	PublishDeliveries(bus, "EUR", webhooks, report)
+
+	counts := counter.Counts()
+	if counts["WebhookDelivered"] != 1 || counts["WebhookFailed"] != 1 || counts["DispatchFinished"] != 1 || len(counts) != 3 {
+		t.Error("Expected one delivered, one failed and one finished event, got ", counts)
+	}
+	okWebhook, _, _ := db.GetWebhook(okID)
+	failingWebhook, _, _ := db.GetWebhook(failingID)
+	if okWebhook.Failures != 0 || failingWebhook.State() != WebhookDisabled {
+		t.Error("Expected the delivery log to reset the working webhook and disable the failing one, got ", okWebhook, failingWebhook)
+	}
+}
+
+func Test_AlertSubscriber(t *testing.T) {
+	var received []AlertPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := AlertPayload{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload)
+	}))
+	defer receiver.Close()
+	Delivery = setupTestDelivery(DefaultDeliveryConfig())
+	defer func(alerts *Alerter) { Alerts = alerts }(Alerts)
+	Alerts = &Alerter{Sinks: []AlertSink{{Type: AlertSinkWebhook, URL: receiver.URL, MinSeverity: SeverityWarning}}}
+
+	subscriber := AlertSubscriber("test")
+	subscriber(SnapshotStored{Currency: Currency{"EUR", "2017-11-20", nil}})
+	subscriber(DispatchFinished{Base: "EUR", Report: DispatchReport{Sent: 2}})
+	subscriber(FetchFailed{Base: "EUR", Date: "latest", Reason: "Failed to get fixer.io currency: timeout"})
+	subscriber(DispatchFinished{Base: "EUR", Report: DispatchReport{Sent: 1, Failed: 1, Results: []DeliveryResult{{ID: "7", Sent: true, Error: "receiver failed"}}}})
+	subscriber(StorageFailed{Operation: "get all webhooks from database", StatusCode: 500, Reason: "no reachable servers"})
+	Alerts.Wait()
+	if len(received) != 3 {
+		t.Error("Expected only the failures to reach a warning-sink, got ", received)
+		return
+	}
+	if received[0].Severity != SeverityError || received[0].Message != "Failed to get fixer.io currency: timeout" || received[0].FromService != "test" {
+		t.Error("Unexpected alert for FetchFailed: ", received[0])
+	}
+	if received[1].Severity != SeverityWarning || received[1].Message != "Failed to send 1 of 2 webhooks for base EUR: 7 (receiver failed)" {
+		t.Error("Unexpected alert for the failed webhooks: ", received[1])
+	}
+	if received[2].Severity != SeverityError || received[2].Message != "Couldn't get all webhooks from database, got statuscode 500, with error: no reachable servers" {
+		t.Error("Unexpected alert for StorageFailed: ", received[2])
+	}
+}
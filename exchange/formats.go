@@ -0,0 +1,131 @@
+package exchange
+
+import "encoding/csv"
+import "encoding/xml"
+import "errors"
+import "net/http"
+import "sort"
+import "strconv"
+import "strings"
+
+// The formats rates can be answered in, chosen with ?format= or the Accept-header.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatXML  = "xml"
+)
+
+// acceptedTypes are the media-types in an Accept-header we know, and the format they give. Browsers ask for text/html first,
+// and get json, so they don't get xml just because they also accept it.
+var acceptedTypes = map[string]string{
+	"application/json": FormatJSON,
+	"text/html":        FormatJSON,
+	"*/*":              FormatJSON,
+	"application/*":    FormatJSON,
+	"text/csv":         FormatCSV,
+	"text/*":           FormatCSV,
+	"application/xml":  FormatXML,
+	"text/xml":         FormatXML,
+}
+
+// negotiateFormat returns the format to answer r in. ?format=json, csv or xml wins over the Accept-header, and json is used if neither is set.
+// The statuscode and error is set if the format asked for is unknown, or nothing in the Accept-header is something we can answer.
+func negotiateFormat(r *http.Request) (string, int, error) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		if format != FormatJSON && format != FormatCSV && format != FormatXML {
+			return "", http.StatusBadRequest, errors.New("format must be json, csv or xml, but you gave us " + format)
+		}
+		return format, http.StatusOK, nil
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSON, http.StatusOK, nil
+	}
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				quality, _ = strconv.ParseFloat(param[2:], 64) // A broken quality is 0, ie. not acceptable
+			}
+		}
+		format, ok := acceptedTypes[strings.ToLower(strings.TrimSpace(fields[0]))]
+		if ok && quality > bestQuality { // The first of equally good types wins
+			best, bestQuality = format, quality
+		}
+	}
+	if best == "" {
+		return "", http.StatusNotAcceptable, errors.New("We can answer with application/json, text/csv or application/xml, but you accept " + accept)
+	}
+	return best, http.StatusOK, nil
+}
+
+// snapshotRates returns the rates of a snapshot as a list sorted by target, like the ones from latest.
+func snapshotRates(currency Currency, asOf string) []RateResponse {
+	rates := []RateResponse{}
+	for target, rate := range currency.Rates {
+		rates = append(rates, RateResponse{currency.Base, target, rate, currency.Date, asOf})
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].TargetCurrency < rates[j].TargetCurrency })
+	return rates
+}
+
+// writeRatesCSV writes rates as csv with a header-row, to be imported in a spreadsheet. Date is the date of the rate that was used.
+func writeRatesCSV(w http.ResponseWriter, rates []RateResponse) {
+	w.Header().Set("content-type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "base", "target", "rate"})
+	for _, rate := range rates {
+		writer.Write([]string{rate.Date, rate.BaseCurrency, rate.TargetCurrency, FloatToString(rate.Rate)})
+	}
+	writer.Flush()
+}
+
+// ecbEnvelope is the layout of the reference-rates from the European Central Bank, ie. https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml,
+// so tools which read those can read ours. The base-attribute is not in the ECB-layout, since theirs are always EUR.
+type ecbEnvelope struct {
+	XMLName   xml.Name      `xml:"gesmes:Envelope"`
+	Gesmes    string        `xml:"xmlns:gesmes,attr"`
+	Namespace string        `xml:"xmlns,attr"`
+	Subject   string        `xml:"gesmes:subject"`
+	Sender    string        `xml:"gesmes:Sender>gesmes:name"`
+	Days      []ecbTimeCube `xml:"Cube>Cube"`
+}
+
+type ecbTimeCube struct {
+	Time  string        `xml:"time,attr"`
+	Base  string        `xml:"base,attr"`
+	Rates []ecbRateCube `xml:"Cube"`
+}
+
+type ecbRateCube struct {
+	Currency string `xml:"currency,attr"`
+	Rate     string `xml:"rate,attr"`
+}
+
+// writeRatesXML writes rates in the ECB-layout, with one time-cube for each date and base in the order they first come in rates.
+func writeRatesXML(w http.ResponseWriter, rates []RateResponse) {
+	envelope := ecbEnvelope{Gesmes: "http://www.gesmes.org/xml/2002-08-01", Namespace: "http://www.ecb.int/vocabulary/2002-08-01/eurofxref",
+		Subject: "Reference rates", Sender: "Currency webhooks"}
+	for _, rate := range rates {
+		day := -1
+		for i, cube := range envelope.Days {
+			if cube.Time == rate.Date && cube.Base == rate.BaseCurrency {
+				day = i
+			}
+		}
+		if day < 0 {
+			envelope.Days = append(envelope.Days, ecbTimeCube{Time: rate.Date, Base: rate.BaseCurrency})
+			day = len(envelope.Days) - 1
+		}
+		envelope.Days[day].Rates = append(envelope.Days[day].Rates, ecbRateCube{rate.TargetCurrency, FloatToString(rate.Rate)})
+	}
+	w.Header().Set("content-type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "\t")
+	encoder.Encode(&envelope)
+}
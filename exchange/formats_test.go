@@ -0,0 +1,93 @@
+package exchange
+
+import "testing"
+import "encoding/csv"
+import "encoding/xml"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+
+func Test_NegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query      string
+		accept     string
+		format     string
+		statusCode int
+	}{
+		{"", "", FormatJSON, http.StatusOK},
+		{"", "text/csv", FormatCSV, http.StatusOK},
+		{"", "application/xml", FormatXML, http.StatusOK},
+		{"", "text/xml;q=0.5, text/csv;q=0.8", FormatCSV, http.StatusOK},
+		{"", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", FormatJSON, http.StatusOK}, // A browser
+		{"", "application/xml, application/json", FormatXML, http.StatusOK},
+		{"", "image/png", "", http.StatusNotAcceptable},
+		{"", "text/csv;q=0", "", http.StatusNotAcceptable},
+		{"?format=CSV", "application/json", FormatCSV, http.StatusOK},
+		{"?format=pdf", "", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/v1/rates"+test.query, nil)
+		r.Header.Set("Accept", test.accept)
+		format, statusCode, _ := negotiateFormat(r)
+		if format != test.format || statusCode != test.statusCode {
+			t.Error("Expected "+test.query+" and Accept: "+test.accept+" to give "+test.format+" and ", test.statusCode, ", but got "+format+" and ", statusCode)
+		}
+	}
+}
+
+func Test_Handler_RatesCSVAndXML(t *testing.T) {
+	db := &memoryStorage{}
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-17", map[string]float32{"NOK": 9.6, "SEK": 9.9, "USD": 1.1795}})
+	DB = db
+	router := NewAPIRouter()
+
+	r := httptest.NewRequest("GET", "/v1/rates?base=EUR", nil)
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("content-type"), "text/csv") || err != nil {
+		t.Error("Expected csv, but got ", w.Code, " with content-type "+w.Header().Get("content-type")+": ", err)
+	} else if len(rows) != 4 || strings.Join(rows[0], ",") != "date,base,target,rate" || strings.Join(rows[3], ",") != "2017-11-17,EUR,USD,1.1795" {
+		t.Error("Expected a header-row and a row for each target sorted by target, but got ", rows)
+	}
+	if w.Header().Get("Vary") != "Accept" {
+		t.Error("Expected Vary: Accept, since the answer depends on it")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/exchange/rates/2017-11-19?base=EUR&target=NOK,SEK&format=xml", nil))
+	envelope := struct {
+		Subject string `xml:"subject"`
+		Days    []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube>Cube"`
+	}{}
+	body := w.Body.String()
+	if err := xml.Unmarshal(w.Body.Bytes(), &envelope); w.Code != http.StatusOK || err != nil {
+		t.Error("Expected xml, but got ", w.Code, ": ", err)
+	} else if !strings.Contains(body, "<gesmes:Envelope") || envelope.Subject != "Reference rates" || len(envelope.Days) != 1 || envelope.Days[0].Time != "2017-11-17" ||
+		len(envelope.Days[0].Rates) != 2 || envelope.Days[0].Rates[0].Currency != "NOK" || envelope.Days[0].Rates[0].Rate != "9.6" {
+		t.Error("Expected the ECB-layout with the rates from 2017-11-17, but got " + body)
+	}
+
+	r = httptest.NewRequest("POST", "/v1/latest", strings.NewReader(`{"baseCurrency": "EUR", "targetCurrency": "USD"}`))
+	r.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "date,base,target,rate\n2017-11-17,EUR,USD,1.1795\n" {
+		t.Error("Expected latest as csv, but got ", w.Code, ": "+w.Body.String())
+	}
+
+	r = httptest.NewRequest("GET", "/v1/latest?base=EUR&target=NOK", nil)
+	r.Header.Set("Accept", "image/png")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), ErrorCodeNotAcceptable) {
+		t.Error("Expected 406 in the error-envelope, but got ", w.Code, ": "+w.Body.String())
+	}
+}
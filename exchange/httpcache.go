@@ -0,0 +1,84 @@
+package exchange
+
+import "net/http"
+import "strconv"
+import "strings"
+import "time"
+
+// RatesUpdateHour is the hour (UTC) new rates are expected on business days. The ECB publishes them around 16:00 CET,
+// and the updater stores them some time after that.
+var RatesUpdateHour = 16
+
+// overdueMaxAge is how long answers are cached when new rates are expected, but not stored yet, so they are picked up soon after they are.
+const overdueMaxAge = 5 * time.Minute
+
+// nextRatesUpdate returns when new rates are next expected after now.
+func nextRatesUpdate(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), RatesUpdateHour, 0, 0, 0, time.UTC)
+	for !next.After(now) || isWeekend(next) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// lastRatesUpdate returns when new rates were last expected, at or before now.
+func lastRatesUpdate(now time.Time) time.Time {
+	now = now.UTC()
+	last := time.Date(now.Year(), now.Month(), now.Day(), RatesUpdateHour, 0, 0, 0, time.UTC)
+	for last.After(now) || isWeekend(last) {
+		last = last.AddDate(0, 0, -1)
+	}
+	return last
+}
+
+func isWeekend(date time.Time) bool {
+	return date.Weekday() == time.Saturday || date.Weekday() == time.Sunday
+}
+
+// cacheMaxAge returns how long an answer made from the snapshot from date (like 2017-11-20) can be cached at now. That is until the next
+// expected update, or overdueMaxAge if the rates from the last expected update aren't stored yet (ie. the updater is late, or it is a holiday).
+func cacheMaxAge(date string, now time.Time) time.Duration {
+	if date < lastRatesUpdate(now).Format("2006-01-02") {
+		return overdueMaxAge
+	}
+	next := nextRatesUpdate(now)
+	if date >= next.Format("2006-01-02") { // The rates of the next update came early, so the answer lasts until the one after
+		next = nextRatesUpdate(next)
+	}
+	return next.Sub(now)
+}
+
+// notModified sets ETag, Last-Modified and Cache-Control for an answer to a GET made from the snapshot from date, and writes 304 Not Modified
+// and returns true if the client already has the answer (If-None-Match or If-Modified-Since). variant tells different answers made from the
+// same snapshot apart, ie. json and csv. Nothing is done for other methods than GET and HEAD.
+func notModified(w http.ResponseWriter, r *http.Request, date string, variant string, now time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	lastModified, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false // Not a snapshot-date, so we can't tell when it changes
+	}
+	etag := `"` + date + "-" + variant + `"`
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(cacheMaxAge(date, now)/time.Second)))
+
+	if match := r.Header.Get("If-None-Match"); match != "" { // If-Modified-Since is ignored when If-None-Match is set
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package exchange
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "time"
+
+func Test_CacheMaxAge(t *testing.T) {
+	tests := []struct {
+		now    string
+		date   string
+		maxAge time.Duration
+	}{
+		{"2017-11-17T10:00:00Z", "2017-11-16", 6 * time.Hour},              // Friday morning, Friday's rates come at 16:00
+		{"2017-11-17T10:00:00Z", "2017-11-15", overdueMaxAge},              // Thursday's rates should have been stored
+		{"2017-11-17T17:00:00Z", "2017-11-17", (3*24 - 1) * time.Hour},     // Friday evening, the next rates come Monday
+		{"2017-11-19T12:00:00Z", "2017-11-17", 28 * time.Hour},             // Sunday
+		{"2017-11-20T15:30:00Z", "2017-11-17", 30 * time.Minute},           // Monday, before the rates come
+		{"2017-11-20T16:30:00Z", "2017-11-17", overdueMaxAge},              // Monday, the rates are late
+		{"2017-11-20T16:00:00Z", "2017-11-20", 24 * time.Hour},             // Just stored
+		{"2017-11-20T16:30:00+01:00", "2017-11-17", 30 * time.Minute},      // Another timezone
+		{"2017-11-20T18:00:00Z", "2017-11-21", (2*24 - 2) * time.Hour},     // Tuesday's rates came early (timezones), so until Wednesday
+		{"2017-11-24T20:00:00Z", "2017-11-24", (2*24 + 20) * time.Hour},    // Friday night
+		{"2017-11-25T00:00:00Z", "2017-11-24", (2*24 + 16) * time.Hour},    // Saturday
+		{"2017-11-27T16:00:00Z", "2017-11-24", overdueMaxAge},              // Monday at 16:00 the rates are expected
+		{"2017-11-27T15:59:59Z", "2017-11-24", time.Second},                // And the second before they are not
+		{"2017-11-27T15:59:59Z", "2017-11-23", overdueMaxAge},              // But Friday's are
+		{"2017-11-27T15:59:59Z", "2017-11-27", 24*time.Hour + time.Second}, // Monday's rates came early
+	}
+	for _, test := range tests {
+		now, _ := time.Parse(time.RFC3339, test.now)
+		if maxAge := cacheMaxAge(test.date, now); maxAge != test.maxAge {
+			t.Error("Expected a snapshot from "+test.date+" to be cached ", test.maxAge, " at "+test.now+", but got ", maxAge)
+		}
+	}
+}
+
+func Test_Handler_RatesCaching(t *testing.T) {
+	db := setupValidationDatabase() // Has a snapshot from 2017-11-20
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-17", map[string]float32{"NOK": 9.5, "SEK": 9.8}})
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-16", map[string]float32{"NOK": 9.4, "SEK": 9.7}})
+	DB = db
+	router := NewAPIRouter()
+	get := func(path string, header string, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/v1/latest?base=EUR&target=NOK", "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"2017-11-20-json"` || w.Header().Get("Last-Modified") != "Mon, 20 Nov 2017 00:00:00 GMT" {
+		t.Error("Expected an ETag and Last-Modified from the snapshot-date, but got ", w.Code, " and ", w.Header())
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=300" { // The snapshot is old, so new rates are overdue
+		t.Error("Expected Cache-Control with a short max-age, but got " + cacheControl)
+	}
+
+	tests := []struct {
+		path       string
+		header     string
+		value      string
+		statusCode int
+	}{
+		{"/v1/latest?base=EUR&target=NOK", "If-None-Match", etag, http.StatusNotModified},
+		{"/v1/latest?base=EUR&target=NOK", "If-None-Match", `"other", W/` + etag, http.StatusNotModified},
+		{"/v1/latest?base=EUR&target=NOK", "If-None-Match", `"2017-11-17-json"`, http.StatusOK},
+		{"/v1/latest?base=EUR&target=NOK&format=csv", "If-None-Match", etag, http.StatusOK}, // Another format is another answer
+		{"/v1/latest?base=EUR&target=NOK", "If-Modified-Since", "Mon, 20 Nov 2017 00:00:00 GMT", http.StatusNotModified},
+		{"/v1/latest?base=EUR&target=NOK", "If-Modified-Since", "Tue, 21 Nov 2017 08:00:00 GMT", http.StatusNotModified},
+		{"/v1/latest?base=EUR&target=NOK", "If-Modified-Since", "Sun, 19 Nov 2017 12:00:00 GMT", http.StatusOK},
+		{"/v1/latest?base=EUR&target=NOK&asOf=2017-11-19", "If-None-Match", `"2017-11-17-json"`, http.StatusNotModified},
+		{"/v1/rates?base=EUR", "If-None-Match", etag, http.StatusNotModified},
+		{"/v1/rates/2017-11-16?base=EUR", "If-None-Match", etag, http.StatusOK},
+		{"/v1/average?base=EUR&target=NOK", "If-None-Match", `"2017-11-20-average"`, http.StatusNotModified},
+		{"/v1/average?base=EUR&target=NOK", "If-None-Match", etag, http.StatusOK},
+	}
+	for _, test := range tests {
+		w = get(test.path, test.header, test.value)
+		if w.Code != test.statusCode {
+			t.Error("GET "+test.path+" with "+test.header+": "+test.value+": expected statuscode ", test.statusCode, ", but got ", w.Code)
+		}
+		if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") == "") {
+			t.Error("GET " + test.path + ": expected 304 with an ETag and without a body, but got " + w.Body.String())
+		}
+	}
+
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.9}})
+	if w = get("/v1/latest?base=EUR&target=NOK", "If-None-Match", etag); w.Code != http.StatusOK || w.Header().Get("ETag") != `"2017-11-21-json"` {
+		t.Error("Expected the new snapshot after it is stored, but got ", w.Code, " and ETag "+w.Header().Get("ETag"))
+	}
+
+	r := httptest.NewRequest("POST", "/v1/latest", strings.NewReader(`{"baseCurrency": "EUR", "targetCurrency": "NOK"}`))
+	r.Header.Set("If-None-Match", `"2017-11-21-json"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Error("Expected POST not to be cached, but got ", w.Code, " and ETag "+w.Header().Get("ETag"))
+	}
+
+	errorTests := []struct {
+		path       string
+		etag       string
+		statusCode int
+	}{
+		{"/v1/average?base=EUR&target=SEK", `"2017-11-21-average"`, http.StatusBadRequest}, // The snapshot from 2017-11-21 has no SEK
+		{"/v1/average?base=USD&target=NOK", "", http.StatusNotFound},
+		{"/v1/latest?base=EUR&target=XYZ", `"2017-11-21-json"`, http.StatusBadRequest},
+	}
+	for _, test := range errorTests {
+		w = get(test.path, "If-None-Match", test.etag)
+		if w.Code != test.statusCode || w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" || w.Header().Get("Last-Modified") != "" {
+			t.Error("GET "+test.path+": expected statuscode ", test.statusCode, " without caching-headers, but got ", w.Code, " and ", w.Header())
+		}
+	}
+}
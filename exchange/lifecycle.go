@@ -0,0 +1,106 @@
+package exchange
+
+import "net/http"
+import "time"
+
+// State returns the status of the webhook, where expired webhooks are WebhookExpired and webhooks without a status are WebhookActive.
+func (webhook Webhook) State() string {
+	if webhook.IsExpired(time.Now()) {
+		return WebhookExpired
+	}
+	if webhook.Status == "" {
+		return WebhookActive
+	}
+	return webhook.Status
+}
+
+// IsActive tells if the webhook should be evaluated and sent.
+func (webhook Webhook) IsActive() bool {
+	return webhook.State() == WebhookActive
+}
+
+// IsExpired tells if the webhook has an expiry-time which is before now.
+func (webhook Webhook) IsExpired(now time.Time) bool {
+	if webhook.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, webhook.ExpiresAt)
+	return err == nil && expiresAt.Before(now)
+}
+
+// RemoveExpiredWebhooks deletes the expired webhooks from storage, and returns the ones that are left.
+func RemoveExpiredWebhooks(storage Storage, webhooks []Webhook) []Webhook {
+	now := time.Now()
+	remaining := []Webhook{}
+	for _, webhook := range webhooks {
+		if !webhook.IsExpired(now) {
+			remaining = append(remaining, webhook)
+			continue
+		}
+		if statusCode, err := storage.DeleteWebhook(webhook.ID); err != nil {
+			Events.Publish(StorageFailed{"delete expired webhook " + webhook.ID, statusCode, err.Error()})
+		}
+	}
+	return remaining
+}
+
+// RecordDeliveries counts failed deliveries in a row for every webhook that was sent in report,
+// and disables the webhooks which have failed Delivery.Config.MaxConsecutiveFailures times.
+func RecordDeliveries(storage Storage, webhooks []Webhook, report DispatchReport) {
+	for i, result := range report.Results {
+		if !result.Sent {
+			continue
+		}
+		recordDelivery(storage, webhooks[i], result.Error != "", "")
+	}
+}
+
+// recordDelivery resets the failures of webhook if the delivery worked, or counts the failure and disables the webhook if it has failed too many times.
+// digestSentAt is stored on the webhook too if it isn't empty and the delivery worked. WebhookAutoDisabled is published to Events when it is disabled.
+func recordDelivery(storage Storage, webhook Webhook, failed bool, digestSentAt string) {
+	if !failed && webhook.Failures == 0 && digestSentAt == "" {
+		return // Nothing has changed
+	}
+	updated, disabled, statusCode, err := storage.RecordWebhookDelivery(webhook.ID, failed, digestSentAt, Delivery.Config.MaxConsecutiveFailures)
+	if err != nil {
+		Events.Publish(StorageFailed{"update webhook " + webhook.ID + " after delivery", statusCode, err.Error()})
+		return
+	}
+	if disabled {
+		Events.Publish(WebhookAutoDisabled{updated})
+	}
+}
+
+// PauseWebhook pauses an active webhook, so it isn't sent before it is resumed.
+func PauseWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	setWebhookStatus(w, id, WebhookPaused, []string{WebhookActive, WebhookPaused})
+}
+
+// ResumeWebhook makes a paused or disabled webhook active again.
+func ResumeWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	setWebhookStatus(w, id, WebhookActive, []string{WebhookActive, WebhookPaused, WebhookDisabled})
+}
+
+// setWebhookStatus changes the status of the webhook with id id to status, if the webhook has one of the statuses in from.
+func setWebhookStatus(w http.ResponseWriter, id string, status string, from []string) {
+	webhook, statusCode, err := DB.GetWebhook(id)
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when getting a webhook: "+err.Error(), nil)
+		return
+	}
+	allowed := false
+	for _, state := range from {
+		allowed = allowed || webhook.State() == state
+	}
+	if !allowed {
+		writeError(w, http.StatusConflict, "The webhook is "+webhook.State()+", and can't be made "+status+".", nil)
+		return
+	}
+
+	webhook, statusCode, err = DB.SetWebhookStatus(id, webhook.Status, status) // Only if nobody has changed the status since it was read
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when updating a webhook: "+err.Error(), nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, &webhook)
+}
@@ -0,0 +1,103 @@
+package exchange
+
+import "testing"
+import "errors"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "time"
+
+func Test_RecordDeliveries(t *testing.T) {
+	defer func(delivery *DeliveryClient) { Delivery = delivery }(Delivery)
+	config := DefaultDeliveryConfig()
+	config.MaxConsecutiveFailures = 3
+	Delivery = setupTestDelivery(config)
+	db := &memoryStorage{}
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6}})
+	okID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://ok.example", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1, Failures: 2})
+	failingID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://failing.example", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1})
+	dispatcher := &Dispatcher{Workers: 2, PerHost: 1, Send: func(notification Notification) (int, error) {
+		if notification.Webhook.ID == failingID {
+			return http.StatusBadRequest, errors.New("receiver failed")
+		}
+		return http.StatusOK, nil
+	}}
+
+	for i := 0; i < 4; i++ {
+		webhooks, _, _ := db.GetAllWebhooks()
+		report := dispatcher.Run(webhooks, LatestEvaluator(db))
+		RecordDeliveries(db, webhooks, report)
+		if i == 3 && report.Results[1].Sent {
+			t.Error("Expected a disabled webhook not to be sent")
+		}
+	}
+	okWebhook, _, _ := db.GetWebhook(okID)
+	failingWebhook, _, _ := db.GetWebhook(failingID)
+	if okWebhook.Failures != 0 || okWebhook.State() != WebhookActive {
+		t.Error("Expected the working webhook to be active without failures, got ", okWebhook)
+	}
+	if failingWebhook.Failures != 3 || failingWebhook.State() != WebhookDisabled {
+		t.Error("Expected the failing webhook to be disabled after 3 failures, got ", failingWebhook)
+	}
+}
+
+func Test_RecordDeliveries_StaleWebhooks(t *testing.T) {
+	db := &memoryStorage{}
+	id, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://failing.example", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1})
+	webhooks, _, _ := db.GetAllWebhooks() // Read before the other deliveries, like by another process
+	disabled := NewEventCounter()
+	Events.Subscribe(disabled.Handle)
+	report := DispatchReport{Results: []DeliveryResult{{ID: id, Sent: true, Error: "receiver failed"}}}
+	for i := 0; i < Delivery.Config.MaxConsecutiveFailures; i++ {
+		RecordDeliveries(db, webhooks, report)
+	}
+	webhook, _, _ := db.GetWebhook(id)
+	if webhook.Failures != Delivery.Config.MaxConsecutiveFailures || webhook.State() != WebhookDisabled || disabled.Counts()["WebhookAutoDisabled"] != 1 {
+		t.Error("Expected every failure to be counted, also from an old copy of the webhook, and it to be disabled once, but got ", webhook, disabled.Counts())
+	}
+
+	if _, statusCode, err := db.SetWebhookStatus(id, WebhookActive, WebhookPaused); err == nil || statusCode != http.StatusConflict {
+		t.Error("Expected the status not to be changed from one it doesn't have anymore, but got ", statusCode)
+	}
+}
+
+func Test_Handler_PauseAndResumeWebhook(t *testing.T) {
+	db := &memoryStorage{}
+	id, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1, Status: WebhookActive})
+	pendingID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1, Status: WebhookPending})
+	oldID, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1}) // Stored before webhooks had a status
+	DB = db
+
+	tests := []struct {
+		path       string
+		statusCode int
+		state      string
+	}{
+		{"/exchange/" + id + "/pause", http.StatusOK, WebhookPaused},
+		{"/exchange/" + id + "/resume", http.StatusOK, WebhookActive},
+		{"/exchange/" + pendingID + "/resume", http.StatusConflict, WebhookPending},
+		{"/exchange/" + pendingID + "/pause", http.StatusConflict, WebhookPending},
+		{"/exchange/" + oldID + "/pause", http.StatusOK, WebhookPaused},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		RegisterWebhook(w, httptest.NewRequest("POST", test.path, strings.NewReader("")))
+		webhook, _, _ := db.GetWebhook(strings.Split(test.path, "/")[2])
+		if w.Code != test.statusCode || webhook.State() != test.state {
+			t.Error("POST "+test.path+": expected statuscode ", test.statusCode, " and state "+test.state+", but got ", w.Code, " and "+webhook.State())
+		}
+	}
+}
+
+func Test_RemoveExpiredWebhooks(t *testing.T) {
+	db := &memoryStorage{}
+	db.RegisterWebhookToDatabase(Webhook{ExpiresAt: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+	db.RegisterWebhookToDatabase(Webhook{ExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)})
+	db.RegisterWebhookToDatabase(Webhook{})
+	webhooks, _, _ := db.GetAllWebhooks()
+	remaining := RemoveExpiredWebhooks(db, webhooks)
+	stored, _, _ := db.GetAllWebhooks()
+	if len(remaining) != 2 || len(stored) != 2 {
+		t.Error("Expected the expired webhook to be deleted, but ", len(stored), " of 3 are still stored")
+	}
+}
@@ -0,0 +1,393 @@
+package exchange
+
+import "net/http"
+import "reflect"
+import "strconv"
+import "strings"
+
+// OpenAPI - This is the struct which holds an OpenAPI 3 document. Only the parts of the specification we use are here.
+type OpenAPI struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       OpenAPIInfo                `json:"info"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+// OpenAPIInfo - The title and version of the api.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIPathItem - The operations of a path, by lower-case method.
+type OpenAPIPathItem map[string]*OpenAPIOperation
+
+// OpenAPIOperation - One method on one path.
+type OpenAPIOperation struct {
+	Summary     string                                `json:"summary"`
+	OperationID string                                `json:"operationId"`
+	Deprecated  bool                                  `json:"deprecated,omitempty"`
+	Parameters  []OpenAPIParameter                    `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody                   `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse           `json:"responses"`
+	Callbacks   map[string]map[string]OpenAPIPathItem `json:"callbacks,omitempty"`
+}
+
+// OpenAPIParameter - A path- or query-parameter.
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIRequestBody - The body of a request, by content-type.
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse - A response, by content-type. Content is empty if the response has no body.
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType - The schema of a body.
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIComponents - The schemas which are referred to with $ref.
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas"`
+}
+
+// OpenAPISchema - A json-schema, as OpenAPI 3 uses it.
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	OneOf                []*OpenAPISchema          `json:"oneOf,omitempty"`
+}
+
+// schemaRegistry makes schemas from Go types. Named structs are added as components and referred to, so the spec follows the structs
+// the handlers really use.
+type schemaRegistry map[string]*OpenAPISchema
+
+func (schemas schemaRegistry) schemaFor(t reflect.Type) *OpenAPISchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemas.schemaFor(t.Elem())
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &OpenAPISchema{Type: "array", Items: schemas.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: schemas.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.objectFor(t)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil // So a struct which refers to itself doesn't loop
+			schemas[t.Name()] = schemas.objectFor(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &OpenAPISchema{} // Anything, ie. interface{}
+}
+
+// objectFor makes the schema of a struct from its json-tags. Embedded structs are merged in, like encoding/json does.
+func (schemas schemaRegistry) objectFor(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range schemas.objectFor(field.Type).Properties {
+				schema.Properties[embeddedName] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemas.schemaFor(field.Type)
+	}
+	return schema
+}
+
+// apiOperation describes one route of NewAPIRouter for the spec. Responses with a nil schema have no body.
+type apiOperation struct {
+	method      string
+	path        string
+	operationID string
+	summary     string
+	parameters  []OpenAPIParameter
+	request     *OpenAPISchema
+	responses   map[int]*OpenAPISchema
+	callbacks   map[string]map[string]OpenAPIPathItem
+}
+
+// legacyPath returns the old /exchange path of a /v1 path, or "" if it has none.
+func legacyPath(path string) string {
+	if strings.HasPrefix(path, "/v1/webhooks") {
+		return "/exchange" + strings.TrimPrefix(path, "/v1/webhooks")
+	}
+	if strings.HasPrefix(path, "/v1/") {
+		return "/exchange/" + strings.TrimPrefix(path, "/v1/")
+	}
+	return ""
+}
+
+func pathParameter(name string, schema *OpenAPISchema) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "path", Required: true, Schema: schema}
+}
+
+func queryParameter(name string, required bool, description string, schema *OpenAPISchema) OpenAPIParameter {
+	return OpenAPIParameter{Name: name, In: "query", Required: required, Description: description, Schema: schema}
+}
+
+// OpenAPISpec returns the OpenAPI 3 document of the api in NewAPIRouter. The /exchange paths are in it as deprecated aliases of /v1.
+func OpenAPISpec() *OpenAPI {
+	schemas := schemaRegistry{}
+	schemaOf := func(value interface{}) *OpenAPISchema { return schemas.schemaFor(reflect.TypeOf(value)) }
+	apiError := schemaOf(ErrorResponse{})
+	withErrors := func(responses map[int]*OpenAPISchema, codes ...int) map[int]*OpenAPISchema {
+		for _, code := range codes {
+			responses[code] = apiError
+		}
+		return responses
+	}
+
+	currency := &OpenAPISchema{Type: "string", Pattern: paramTypes["currency"].String()}
+	date := &OpenAPISchema{Type: "string", Format: "date"}
+	id := pathParameter("id", &OpenAPISchema{Type: "string"})
+	base := queryParameter("base", true, "The base-currency, like EUR", currency)
+	targets := func(required bool) OpenAPIParameter {
+		return queryParameter("target", required, "Target-currencies, like NOK or NOK,SEK. It can be repeated.", &OpenAPISchema{Type: "array", Items: currency})
+	}
+	asOf := queryParameter("asOf", false, "Use the rates in effect on this date instead of the latest. A weekend or holiday uses the last business day before it.", date)
+	noAsOf := queryParameter("asOf", false, "Not supported, the average is always of the last 3 rates. It is answered with 400 validation_failed.", date)
+	oneOrMoreRates := &OpenAPISchema{OneOf: []*OpenAPISchema{schemaOf(RateResponse{}), schemaOf([]RateResponse{})},
+		Description: "One target is answered as an object, several as a list in the order they were asked for."}
+	notification := map[string]map[string]OpenAPIPathItem{
+		"verification": {"{$request.body#/webhookurl}": {"post": &OpenAPIOperation{Summary: "The challenge, which the receiver must answer with", OperationID: "verificationCallback",
+			RequestBody: &OpenAPIRequestBody{true, map[string]OpenAPIMediaType{"application/json": {schemaOf(VerificationRequest{})}}},
+			Responses:   map[string]*OpenAPIResponse{"200": {Description: "Only the challenge, as text/plain. The request echoed back as json doesn't verify the webhook.", Content: map[string]OpenAPIMediaType{"text/plain": {&OpenAPISchema{Type: "string"}}}}}}}},
+		"notification": {"{$request.body#/webhookurl}": {"post": &OpenAPIOperation{Summary: "Sent when the rate is inside the trigger-range", OperationID: "notificationCallback",
+			RequestBody: &OpenAPIRequestBody{true, map[string]OpenAPIMediaType{"application/json": {schemaOf(SendWebhook{})}}},
+			Responses:   map[string]*OpenAPIResponse{"200": {Description: "Received"}, "204": {Description: "Received"}}}}},
+	}
+
+	operations := []apiOperation{
+		{"POST", "/v1/webhooks", "createWebhook", "Register a webhook. It is pending until it is verified.", nil, schemaOf(Webhook{}),
+			withErrors(map[int]*OpenAPISchema{201: schemaOf(Webhook{})}, 400, 500, 501), notification},
+		{"GET", "/v1/webhooks/{id}", "getWebhook", "Get a webhook", []OpenAPIParameter{id}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(Webhook{})}, 404, 500), nil},
+		{"DELETE", "/v1/webhooks/{id}", "deleteWebhook", "Delete a webhook", []OpenAPIParameter{id}, nil,
+			withErrors(map[int]*OpenAPISchema{204: nil}, 404, 500), nil},
+		{"POST", "/v1/webhooks/{id}/test", "testWebhook", "Send the latest rate to a webhook, even outside its trigger-range", []OpenAPIParameter{id}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(TestFireResult{})}, 400, 404, 409, 500), nil},
+		{"POST", "/v1/webhooks/{id}/verify", "verifyWebhook", "Verify a pending webhook again. Email-webhooks POST the challenge from the email.", []OpenAPIParameter{id}, schemaOf(VerificationRequest{}),
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(Webhook{})}, 400, 404, 409, 500), nil},
+		{"POST", "/v1/webhooks/{id}/pause", "pauseWebhook", "Pause an active webhook", []OpenAPIParameter{id}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(Webhook{})}, 404, 409, 500), nil},
+		{"POST", "/v1/webhooks/{id}/resume", "resumeWebhook", "Make a paused or disabled webhook active again", []OpenAPIParameter{id}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(Webhook{})}, 404, 409, 500), nil},
+		{"GET", "/v1/latest", "getLatest", "The latest rates", []OpenAPIParameter{base, targets(true), asOf}, nil,
+			withErrors(map[int]*OpenAPISchema{200: oneOrMoreRates}, 400, 404, 500), nil},
+		{"POST", "/v1/latest", "postLatest", "The latest rate", nil, schemaOf(CurrencyRequest{}),
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(RateResponse{})}, 400, 404, 500), nil},
+		{"GET", "/v1/average", "getAverage", "The average of the last 3 rates", []OpenAPIParameter{base, targets(true), noAsOf}, nil,
+			withErrors(map[int]*OpenAPISchema{200: oneOrMoreRates}, 400, 404, 500), nil},
+		{"POST", "/v1/average", "postAverage", "The average of the last 3 rates. asOf isn't supported, and is answered with 400 validation_failed", nil, schemaOf(CurrencyRequest{}),
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(RateResponse{})}, 400, 404, 500), nil},
+		{"GET", "/v1/rates", "getRates", "The latest snapshot for a base, with all or some of its rates", []OpenAPIParameter{base, targets(false), asOf}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(RatesResponse{})}, 400, 404, 500), nil},
+		{"GET", "/v1/rates/{date}", "getRatesAsOf", "The snapshot for a base in effect on a date", []OpenAPIParameter{pathParameter("date", date), base, targets(false)}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(RatesResponse{})}, 400, 404, 500), nil},
+		{"POST", "/v1/bot/latest", "botLatest", "The latest rate, answered for a Dialogflow-bot", nil, schemaOf(BotRequest{}),
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(BotAnswer{})}, 400, 404, 500), nil},
+		{"GET", "/v1/evaluationtrigger", "evaluationTrigger", "Evaluate all webhooks against the latest rates, and send the ones inside their trigger-range",
+			[]OpenAPIParameter{queryParameter("dryRun", false, "Only evaluate, don't send anything", &OpenAPISchema{Type: "boolean"})}, nil,
+			withErrors(map[int]*OpenAPISchema{200: schemaOf(DispatchReport{})}, 400, 500), nil},
+		{"GET", "/openapi.json", "openAPI", "This document", nil, nil, map[int]*OpenAPISchema{200: {Type: "object"}}, nil},
+		{"GET", "/docs", "docs", "The documentation of the api, made from this document", nil, nil, map[int]*OpenAPISchema{200: {Type: "string"}}, nil},
+	}
+
+	format := queryParameter("format", false, "json, csv or xml (in the ECB-layout). It wins over the Accept-header.", &OpenAPISchema{Type: "string"})
+	conditional := []OpenAPIParameter{
+		{Name: "If-None-Match", In: "header", Description: "The ETag of the answer the client has", Schema: &OpenAPISchema{Type: "string"}},
+		{Name: "If-Modified-Since", In: "header", Description: "The Last-Modified of the answer the client has", Schema: &OpenAPISchema{Type: "string"}},
+	}
+	for i := range operations {
+		operations[i].responses[http.StatusTooManyRequests] = apiError // See RateLimiter
+		if rateFormatOperations[operations[i].operationID] {
+			operations[i].parameters = append(append([]OpenAPIParameter{}, operations[i].parameters...), format)
+			operations[i].responses[http.StatusNotAcceptable] = apiError
+		}
+		if cachedOperations[operations[i].operationID] {
+			operations[i].parameters = append(append([]OpenAPIParameter{}, operations[i].parameters...), conditional...)
+			operations[i].responses[http.StatusNotModified] = nil
+		}
+	}
+
+	spec := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{Title: "Currency webhooks", Version: "1.0.0",
+			Description: "Currency-rates, and webhooks which are notified when a rate is inside their trigger-range. Errors are sent as {\"error\": {\"code\", \"message\", \"details\"}}. " +
+				"Requests are rate-limited per ip-address, or per api-key if one is sent in X-API-Key, and the limits are told in the RateLimit-headers."},
+		Paths:      map[string]OpenAPIPathItem{},
+		Components: OpenAPIComponents{Schemas: schemas},
+	}
+	for _, operation := range operations {
+		spec.add(operation.path, operation.method, operation.openAPIOperation(false))
+		if legacy := legacyPath(operation.path); legacy != "" {
+			spec.add(legacy, operation.method, operation.openAPIOperation(true))
+		}
+	}
+	return spec
+}
+
+func (spec *OpenAPI) add(path string, method string, operation *OpenAPIOperation) {
+	if spec.Paths[path] == nil {
+		spec.Paths[path] = OpenAPIPathItem{}
+	}
+	spec.Paths[path][strings.ToLower(method)] = operation
+}
+
+// rateFormatOperations are the operations which can answer in csv and xml too (see negotiateFormat).
+var rateFormatOperations = map[string]bool{"getLatest": true, "postLatest": true, "getRates": true, "getRatesAsOf": true}
+
+// cachedOperations are the operations which answer with ETag, Last-Modified and Cache-Control, and 304 Not Modified (see notModified).
+var cachedOperations = map[string]bool{"getLatest": true, "getAverage": true, "getRates": true, "getRatesAsOf": true}
+
+// openAPIOperation makes the operation for the /v1 path, or for the deprecated /exchange path if legacy is set.
+func (operation apiOperation) openAPIOperation(legacy bool) *OpenAPIOperation {
+	result := &OpenAPIOperation{Summary: operation.summary, OperationID: operation.operationID, Deprecated: legacy,
+		Parameters: operation.parameters, Responses: map[string]*OpenAPIResponse{}, Callbacks: operation.callbacks}
+	if legacy {
+		result.OperationID += "Legacy"
+		result.Summary += " (use " + operation.path + ")"
+	}
+	if operation.request != nil {
+		required := !strings.HasSuffix(operation.path, "/verify") // Only email-webhooks send a body when verifying
+		result.RequestBody = &OpenAPIRequestBody{required, map[string]OpenAPIMediaType{"application/json": {operation.request}}}
+	}
+	for statusCode, schema := range operation.responses {
+		response := &OpenAPIResponse{Description: http.StatusText(statusCode)}
+		if schema != nil {
+			contentType := "application/json"
+			if operation.path == "/docs" {
+				contentType = "text/html"
+			}
+			response.Content = map[string]OpenAPIMediaType{contentType: {schema}}
+			if statusCode == http.StatusOK && rateFormatOperations[operation.operationID] {
+				response.Content["text/csv"] = OpenAPIMediaType{&OpenAPISchema{Type: "string", Description: "date,base,target,rate"}}
+				response.Content["application/xml"] = OpenAPIMediaType{&OpenAPISchema{Type: "string", Description: "The ECB-layout"}}
+			}
+		}
+		result.Responses[strconv.Itoa(statusCode)] = response
+	}
+	return result
+}
+
+// ServeOpenAPI answers with the OpenAPI 3 document of the api.
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPISpec())
+}
+
+// ServeDocs answers with a page which shows the api from /openapi.json.
+func ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(docsPage))
+}
+
+// docsPage is kept without external scripts, so the docs work without access to a CDN.
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Currency webhooks - API</title>
+<style>
+body { font-family: sans-serif; max-width: 960px; margin: 2em auto; color: #222; }
+h2 { margin-top: 2em; }
+.operation { border: 1px solid #ddd; border-radius: 4px; margin: 1em 0; padding: 0.5em 1em; }
+.deprecated { opacity: 0.6; }
+.method { font-weight: bold; display: inline-block; width: 5em; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1 id="title">API</h1>
+<p id="description"></p>
+<p><a href="/openapi.json">openapi.json</a></p>
+<div id="operations"></div>
+<script>
+fetch("/openapi.json").then(function (response) { return response.json(); }).then(function (spec) {
+	document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+	document.getElementById("description").textContent = spec.info.description;
+	var resolve = function (schema) {
+		if (schema && schema["$ref"]) {
+			return spec.components.schemas[schema["$ref"].split("/").pop()];
+		}
+		return schema;
+	};
+	var operations = document.getElementById("operations");
+	Object.keys(spec.paths).sort().forEach(function (path) {
+		Object.keys(spec.paths[path]).forEach(function (method) {
+			var operation = spec.paths[path][method];
+			var element = document.createElement("div");
+			element.className = "operation" + (operation.deprecated ? " deprecated" : "");
+			var heading = document.createElement("h3");
+			heading.innerHTML = '<span class="method"></span><code></code>';
+			heading.children[0].textContent = method.toUpperCase();
+			heading.children[1].textContent = path;
+			element.appendChild(heading);
+			var summary = document.createElement("p");
+			summary.textContent = operation.summary;
+			element.appendChild(summary);
+			var details = [];
+			(operation.parameters || []).forEach(function (parameter) {
+				details.push(parameter.in + " " + parameter.name + (parameter.required ? " (required)" : "") + (parameter.description ? ": " + parameter.description : ""));
+			});
+			if (operation.requestBody) {
+				details.push("body: " + JSON.stringify(resolve(operation.requestBody.content["application/json"].schema), null, 2));
+			}
+			Object.keys(operation.responses).sort().forEach(function (statusCode) {
+				var response = operation.responses[statusCode];
+				var content = response.content && (response.content["application/json"] || response.content["text/html"]);
+				details.push(statusCode + " " + response.description + (content && content.schema["$ref"] ? ": " + content.schema["$ref"].split("/").pop() : ""));
+			});
+			var pre = document.createElement("pre");
+			pre.textContent = details.join("\n");
+			element.appendChild(pre);
+			operations.appendChild(element);
+		});
+	});
+});
+</script>
+</body>
+</html>
+`
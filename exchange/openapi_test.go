@@ -0,0 +1,226 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "errors"
+import "net/http"
+import "net/http/httptest"
+import "regexp"
+import "strconv"
+import "strings"
+
+// checkSchema checks that value (decoded from json) follows schema. Properties which aren't in the schema are errors,
+// so a field which is sent but not documented is found.
+func checkSchema(spec *OpenAPI, schema *OpenAPISchema, value interface{}, at string) error {
+	if schema.Ref != "" {
+		return checkSchema(spec, spec.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")], value, at)
+	}
+	if len(schema.OneOf) > 0 {
+		for _, oneOf := range schema.OneOf {
+			if checkSchema(spec, oneOf, value, at) == nil {
+				return nil
+			}
+		}
+		return errors.New(at + " is none of the schemas in oneOf")
+	}
+	ok := true
+	switch schema.Type {
+	case "object":
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return errors.New(at + " is not an object")
+		}
+		for name, property := range object {
+			propertySchema, found := schema.Properties[name]
+			if !found && schema.AdditionalProperties != nil {
+				propertySchema, found = schema.AdditionalProperties, true
+			}
+			if !found && (schema.Properties != nil) {
+				return errors.New(at + "." + name + " is not in the spec")
+			}
+			if found {
+				if err := checkSchema(spec, propertySchema, property, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, isArray := value.([]interface{})
+		if !isArray {
+			return errors.New(at + " is not an array")
+		}
+		for i, item := range array {
+			if err := checkSchema(spec, schema.Items, item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		_, ok = value.(string)
+	case "boolean":
+		_, ok = value.(bool)
+	case "number":
+		_, ok = value.(float64)
+	case "integer":
+		number, isNumber := value.(float64)
+		ok = isNumber && number == float64(int64(number))
+	}
+	if !ok {
+		return errors.New(at + " is not a " + schema.Type)
+	}
+	return nil
+}
+
+func Test_OpenAPI_MatchesRouter(t *testing.T) {
+	spec := OpenAPISpec()
+	typeOfParam := regexp.MustCompile(`\{(\w+):\w+\}`)
+	routed := map[string]bool{}
+	for _, route := range NewAPIRouter().Routes() {
+		path := typeOfParam.ReplaceAllString(route.Pattern, "{$1}")
+		routed[route.Method+" "+path] = true
+		if spec.Paths[path][strings.ToLower(route.Method)] == nil {
+			t.Error("The route " + route.Method + " " + route.Pattern + " is not in the spec")
+		}
+	}
+	for path, item := range spec.Paths {
+		for method := range item {
+			if !routed[strings.ToUpper(method)+" "+path] {
+				t.Error("The spec has " + strings.ToUpper(method) + " " + path + ", but the router doesn't")
+			}
+		}
+	}
+
+	for name, schema := range spec.Components.Schemas {
+		if schema == nil || len(schema.Properties) == 0 {
+			t.Error("Expected the schema " + name + " to have properties")
+		}
+	}
+	for _, name := range []string{"Webhook", "CurrencyRequest", "BotRequest", "BotAnswer", "SendWebhook", "ErrorResponse"} {
+		if spec.Components.Schemas[name] == nil {
+			t.Error("Expected the schema " + name + " in the spec")
+		}
+	}
+	if _, err := json.Marshal(spec); err != nil {
+		t.Error("Couldn't make json of the spec: ", err)
+	}
+}
+
+func Test_OpenAPI_MatchesHandlers(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := VerificationRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(request.Challenge))
+	}))
+	defer receiver.Close()
+	db := setupValidationDatabase()
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.7, "SEK": 10}})
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-22", map[string]float32{"NOK": 9.8, "SEK": 10.1}})
+	DB = db
+	Egress = setupTestEgress([]string{"127.0.0.1"}, nil)
+	Delivery = NewDeliveryClient(DefaultDeliveryConfig(), Egress)
+
+	spec := OpenAPISpec()
+	operations := NewRouter() // Finds the operation in the spec for a request
+	var matched *OpenAPIOperation
+	for path, item := range spec.Paths {
+		for method, operation := range item {
+			operation := operation
+			operations.Handle(method, path, func(w http.ResponseWriter, r *http.Request, params Params) { matched = operation })
+		}
+	}
+
+	webhook := `{"webhookurl": "` + receiver.URL + `", "baseCurrency": "EUR", "targetCurrency": "NOK", "minTriggerValue": 1, "maxTriggerValue": 20}`
+	bot := `{"lang": "en", "result": {"parameters": {"baseCurrency": "EUR", "targetCurrency": "NOK"}}}`
+	requests := []struct{ method, path, body string }{
+		{"POST", "/v1/webhooks", webhook},
+		{"POST", "/v1/webhooks", `{"baseCurrency": "EUR"}`},
+		{"POST", "/v1/webhooks", `{"baseCurrency": "USD"}`},
+		{"GET", "/v1/webhooks/{id}", ""},
+		{"GET", "/v1/webhooks/unknown", ""},
+		{"POST", "/v1/webhooks/{id}/test", ""},
+		{"POST", "/v1/webhooks/{id}/verify", ""},
+		{"POST", "/v1/webhooks/{id}/pause", ""},
+		{"POST", "/v1/webhooks/{id}/pause", ""},
+		{"POST", "/v1/webhooks/{id}/resume", ""},
+		{"GET", "/v1/latest?base=EUR&target=NOK", ""},
+		{"GET", "/v1/latest?base=EUR&target=NOK,SEK&asOf=2017-11-21", ""},
+		{"GET", "/v1/latest?base=EUR", ""},
+		{"POST", "/v1/latest", `{"baseCurrency": "EUR", "targetCurrency": "NOK"}`},
+		{"POST", "/v1/latest", `{"baseCurrency": "EUR", "targetCurrency": "NOK", "asOf": "2001-01-01"}`},
+		{"GET", "/v1/average?base=EUR&target=NOK,SEK", ""},
+		{"POST", "/v1/average", `{"baseCurrency": "EUR", "targetCurrency": "NOK"}`},
+		{"POST", "/v1/average", `{"baseCurrency": "EUR", "targetCurrency": "XYZ"}`},
+		{"GET", "/v1/average?base=EUR&target=NOK&asOf=2017-11-21", ""},
+		{"GET", "/v1/rates?base=EUR", ""},
+		{"GET", "/v1/rates/2017-11-21?base=EUR&target=SEK", ""},
+		{"GET", "/v1/rates/2001-01-01?base=EUR", ""},
+		{"GET", "/v1/rates?base=EUR&format=csv", ""},
+		{"GET", "/v1/latest?base=EUR&target=NOK&format=xml", ""},
+		{"GET", "/v1/latest?base=EUR&target=NOK&format=pdf", ""},
+		{"POST", "/v1/bot/latest", bot},
+		{"POST", "/v1/bot/latest", "{"},
+		{"GET", "/v1/evaluationtrigger?dryRun=true", ""},
+		{"GET", "/v1/evaluationtrigger?dryRun=maybe", ""},
+		{"GET", "/v1/evaluationtrigger?dryRun=true", ""}, // Over the limit
+		{"GET", "/openapi.json", ""},
+		{"GET", "/docs", ""},
+		{"DELETE", "/v1/webhooks/{id}", ""},
+		{"DELETE", "/v1/webhooks/{id}", ""},
+	}
+
+	router := NewAPIRouter()
+	router.Use(NewRateLimiter(DefaultRateLimitConfig()).Middleware)
+	succeeded := map[string]bool{}
+	id := ""
+	for _, request := range requests {
+		path := strings.Replace(request.path, "{id}", id, 1)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(request.method, path, strings.NewReader(request.body)))
+		if request.path == "/v1/webhooks" && w.Code == http.StatusCreated {
+			created := Webhook{}
+			json.Unmarshal(w.Body.Bytes(), &created)
+			id = created.ID
+		}
+
+		matched = nil
+		operations.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(request.method, path, nil))
+		if matched == nil {
+			t.Error(request.method + " " + path + " is not in the spec")
+			continue
+		}
+		response := matched.Responses[strconv.Itoa(w.Code)]
+		if response == nil {
+			t.Error(request.method+" "+path+" answered with statuscode ", w.Code, ", which is not in the spec: ", w.Body.String())
+			continue
+		}
+		if w.Code < 300 {
+			succeeded[matched.OperationID] = true
+		}
+		if len(response.Content) == 0 {
+			if w.Body.Len() != 0 {
+				t.Error(request.method + " " + path + " answered with a body, but the spec has none: " + w.Body.String())
+			}
+			continue
+		}
+		contentType := strings.TrimSpace(strings.Split(w.Header().Get("content-type"), ";")[0])
+		media, ok := response.Content[contentType]
+		if !ok {
+			t.Error(request.method+" "+path+" answered with content-type "+contentType+", but the spec has ", response.Content)
+		} else if contentType == "application/json" {
+			var body interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Error(request.method+" "+path+" didn't answer with json: ", err)
+			} else if err := checkSchema(spec, media.Schema, body, "body"); err != nil {
+				t.Error(request.method+" "+path+" answered "+w.Body.String()+", which doesn't follow the spec: ", err)
+			}
+		}
+	}
+
+	for path, item := range spec.Paths {
+		for method, operation := range item {
+			if !operation.Deprecated && !succeeded[operation.OperationID] {
+				t.Error("The operation " + strings.ToUpper(method) + " " + path + " was never tested with a successful request")
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package exchange
+
+import "bytes"
+import "encoding/json"
+import "errors"
+import "mime"
+import "text/template"
+import "text/template/parse"
+
+// MaxPayloadTemplateLength is the longest payload-template we accept, and the longest payload it may render.
+const MaxPayloadTemplateLength = 10000
+
+// PayloadData - This is the struct which payload-templates are rendered with, ie. {{.CurrentRate}} or {{.Pair}}.
+type PayloadData struct {
+	BaseCurrency    string
+	TargetCurrency  string
+	Pair            string // Like EUR/NOK
+	CurrentRate     float32
+	MinTriggerValue float32
+	MaxTriggerValue float32
+	Date            string
+	HasPrevious     bool    // If false, PreviousRate, Change and ChangePercent are 0
+	PreviousRate    float32 // The rate from the snapshot before Date
+	Change          float32 // CurrentRate - PreviousRate
+	ChangePercent   float32
+}
+
+// Functions usable in payload-templates, ie. {"text": {{json .Pair}}} to get a quoted and escaped string.
+var payloadFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"rate": FloatToString,
+}
+
+// NewPayloadData makes the data a notification is rendered with.
+func NewPayloadData(notification Notification) PayloadData {
+	webhook := notification.Webhook
+	data := PayloadData{
+		BaseCurrency:    webhook.BaseCurrency,
+		TargetCurrency:  webhook.TargetCurrency,
+		Pair:            webhook.BaseCurrency + "/" + webhook.TargetCurrency,
+		CurrentRate:     notification.CurrentRate,
+		MinTriggerValue: webhook.MinTriggerValue,
+		MaxTriggerValue: webhook.MaxTriggerValue,
+		Date:            notification.Date,
+		HasPrevious:     notification.PreviousRate != 0,
+		PreviousRate:    notification.PreviousRate,
+	}
+	if data.HasPrevious {
+		data.Change = data.CurrentRate - data.PreviousRate
+		data.ChangePercent = data.Change / data.PreviousRate * 100
+	}
+	return data
+}
+
+func parsePayloadTemplate(payloadTemplate string) (*template.Template, error) {
+	if len(payloadTemplate) > MaxPayloadTemplateLength {
+		return nil, errors.New("The payload-template is longer than the limit of 10000 characters")
+	}
+	parsedTemplate, err := template.New("payload").Funcs(payloadFuncs).Option("missingkey=error").Parse(payloadTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsedTemplate.Templates()) > 1 {
+		return nil, errors.New("{{define}} and {{block}} can't be used in a payload-template")
+	}
+	return parsedTemplate, checkPayloadNode(parsedTemplate.Tree.Root)
+}
+
+// checkPayloadNode returns an error if node, or a node in it, is a {{range}} or {{template}}. With these a short template can do
+// an unbounded amount of work (like a template which calls the next one ten times, nested) before it writes anything.
+func checkPayloadNode(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := checkPayloadNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		if err := checkPayloadNode(node.List); err != nil {
+			return err
+		}
+		return checkPayloadNode(node.ElseList)
+	case *parse.WithNode:
+		if err := checkPayloadNode(node.List); err != nil {
+			return err
+		}
+		return checkPayloadNode(node.ElseList)
+	case *parse.RangeNode:
+		return errors.New("{{range}} can't be used in a payload-template")
+	case *parse.TemplateNode:
+		return errors.New("{{template}} can't be used in a payload-template")
+	}
+	return nil
+}
+
+func renderPayloadTemplate(payloadTemplate string, data PayloadData) ([]byte, error) {
+	parsedTemplate, err := parsePayloadTemplate(payloadTemplate)
+	if err != nil {
+		return nil, err
+	}
+	payload := &limitedBuffer{limit: MaxPayloadTemplateLength}
+	if err := parsedTemplate.Execute(payload, data); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// errPayloadTooLong stops a template while it is rendered, so one which writes a lot (like {{printf "%0999999d" 0}} many times)
+// can't use up the memory before the length is checked.
+var errPayloadTooLong = errors.New("The rendered payload is longer than the limit of 10000 characters")
+
+// limitedBuffer is a bytes.Buffer which returns errPayloadTooLong instead of growing past limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (buffer *limitedBuffer) Write(p []byte) (int, error) {
+	if buffer.Len()+len(p) > buffer.limit {
+		return 0, errPayloadTooLong
+	}
+	return buffer.Buffer.Write(p)
+}
+
+// ValidatePayloadTemplate checks that the template can be parsed and rendered, and that the content-type is valid.
+func ValidatePayloadTemplate(payloadTemplate string, contentType string) (templateErr error, contentTypeErr error) {
+	if contentType != "" {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			contentTypeErr = err
+		}
+	}
+	if payloadTemplate == "" {
+		return nil, contentTypeErr
+	}
+	sample := NewPayloadData(Notification{Webhook: Webhook{BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, MaxTriggerValue: 10}, CurrentRate: 9.5, PreviousRate: 9.4, Date: "2017-11-20"})
+	_, templateErr = renderPayloadTemplate(payloadTemplate, sample)
+	return templateErr, contentTypeErr
+}
+
+// BuildPayload returns the body and content-type to send for a notification.
+// Chat-tools get a formatted message, webhooks with a payload-template get it rendered, and the others get SendWebhook as json.
+func BuildPayload(notification Notification) ([]byte, string, error) {
+	webhook := notification.Webhook
+	switch webhook.Kind {
+	case WebhookKindSlack:
+		payload, err := BuildSlackMessage(NewPayloadData(notification))
+		return payload, "application/json", err
+	case WebhookKindDiscord:
+		payload, err := BuildDiscordMessage(NewPayloadData(notification))
+		return payload, "application/json", err
+	}
+
+	contentType := webhook.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if webhook.PayloadTemplate != "" {
+		payload, err := renderPayloadTemplate(webhook.PayloadTemplate, NewPayloadData(notification))
+		return payload, contentType, err
+	}
+
+	sendWebhook := SendWebhook{}
+	sendWebhook.BaseCurrency = webhook.BaseCurrency
+	sendWebhook.CurrentRate = notification.CurrentRate
+	sendWebhook.MaxTriggerValue = webhook.MaxTriggerValue
+	sendWebhook.MinTriggerValue = webhook.MinTriggerValue
+	sendWebhook.TargetCurrency = webhook.TargetCurrency
+	payload, err := json.Marshal(&sendWebhook)
+	return payload, "application/json", err
+}
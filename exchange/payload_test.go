@@ -0,0 +1,59 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "strconv"
+import "strings"
+
+func Test_BuildPayload(t *testing.T) {
+	webhook := Webhook{BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 9, MaxTriggerValue: 10}
+	notification := Notification{Webhook: webhook, CurrentRate: 9.5, PreviousRate: 9.4, Date: "2017-11-20"}
+
+	payload, contentType, err := BuildPayload(notification)
+	sendWebhook := SendWebhook{}
+	if err != nil || contentType != "application/json" || json.Unmarshal(payload, &sendWebhook) != nil || sendWebhook.CurrentRate != 9.5 {
+		t.Error("Expected webhooks without template to get SendWebhook as json, got "+string(payload)+", ", err)
+	}
+
+	notification.Webhook.PayloadTemplate = `{"text": {{json .Pair}}, "rate": {{rate .CurrentRate}}, "date": "{{.Date}}", "up": {{gt .Change 0.0}}}`
+	notification.Webhook.ContentType = "application/vnd.custom+json"
+	payload, contentType, err = BuildPayload(notification)
+	expected := `{"text": "EUR/NOK", "rate": 9.5, "date": "2017-11-20", "up": true}`
+	if err != nil || string(payload) != expected || contentType != "application/vnd.custom+json" {
+		t.Error("Expected the template to render as "+expected+" with the given content-type, got "+string(payload)+" ("+contentType+"), ", err)
+	}
+}
+
+func Test_ValidatePayloadTemplate(t *testing.T) {
+	nested := `{{define "0"}}xxxxxxxxxx{{end}}`
+	for level := 1; level <= 8; level++ { // Every level renders the one before it 10 times
+		nested += `{{define "` + strconv.Itoa(level) + `"}}` + strings.Repeat(`{{template "`+strconv.Itoa(level-1)+`"}}`, 10) + `{{end}}`
+	}
+	nested += `{{template "8"}}`
+
+	tests := []struct {
+		payloadTemplate string
+		contentType     string
+		templateOK      bool
+		contentTypeOK   bool
+	}{
+		{"", "", true, true},
+		{"{{.CurrentRate}}", "text/plain; charset=utf-8", true, true},
+		{"{{.CurrentRate", "", false, true},
+		{"{{.NoSuchField}}", "", false, true},
+		{"{{nosuchfunc .Pair}}", "", false, true},
+		{"{{.Pair}}", "not a content-type;;", true, false},
+		{nested, "", false, true}, // Would render 1GB, and keep going without output if the innermost one was empty
+		{`{{define "x"}}{{.Pair}}{{end}}`, "", false, true},
+		{`{{block "x" .}}{{.Pair}}{{end}}`, "", false, true},
+		{`{{if .HasPrevious}}{{.Pair}}{{else}}{{range $i, $c := .Pair}}{{end}}{{end}}`, "", false, true},
+		{`{{with .Pair}}{{.}}{{end}}`, "", true, true},
+		{`{{printf "%0999999d" 0}}`, "", false, true}, // Longer than the limit
+	}
+	for _, test := range tests {
+		templateErr, contentTypeErr := ValidatePayloadTemplate(test.payloadTemplate, test.contentType)
+		if (templateErr == nil) != test.templateOK || (contentTypeErr == nil) != test.contentTypeOK {
+			t.Error("Unexpected validation of template "+test.payloadTemplate+" with content-type "+test.contentType+": ", templateErr, contentTypeErr)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package exchange
+
+import "encoding/json"
+import "errors"
+import "math"
+import "net"
+import "net/http"
+import "os"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// RateLimit - How many requests can be made: Requests per Per, in bursts of up to Burst (Requests if 0). Requests 0 is no limit.
+type RateLimit struct {
+	Requests int      `json:"requests" yaml:"requests"`
+	Per      Duration `json:"per" yaml:"per"`
+	Burst    int      `json:"burst,omitempty" yaml:"burst"`
+}
+
+// RouteRateLimit - The limits of the routes with Path, like /evaluationtrigger, both under /v1 and /exchange. PerIP and PerKey
+// default to the ones in RateLimitConfig. Total is shared by every client, so many clients together can't go over it either.
+type RouteRateLimit struct {
+	Path   string    `json:"path" yaml:"path"`
+	PerIP  RateLimit `json:"perIP" yaml:"perIP"`
+	PerKey RateLimit `json:"perKey" yaml:"perKey"`
+	Total  RateLimit `json:"total" yaml:"total"`
+}
+
+// RateLimitConfig - The limits of the api. Requests with a known api-key in X-API-Key are limited per key, the others per ip-address.
+// TrustForwardedFor uses the address a proxy (like the Heroku-router) added to X-Forwarded-For, and must only be set behind one.
+type RateLimitConfig struct {
+	PerIP             RateLimit        `json:"perIP" yaml:"perIP"`
+	PerKey            RateLimit        `json:"perKey" yaml:"perKey"`
+	Routes            []RouteRateLimit `json:"routes" yaml:"routes"`
+	APIKeys           []string         `json:"apiKeys" yaml:"apiKeys"` // Is redacted when logged
+	TrustForwardedFor bool             `json:"trustForwardedFor" yaml:"trustForwardedFor"`
+}
+
+// DefaultRateLimitConfig returns the limits used when nothing else is set. The evaluation-trigger sends to every subscriber, so
+// it is far stricter than the rates.
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		PerIP:  RateLimit{Requests: 60, Per: Duration{time.Minute}},
+		PerKey: RateLimit{Requests: 600, Per: Duration{time.Minute}},
+		Routes: []RouteRateLimit{{
+			Path:   "/evaluationtrigger",
+			PerIP:  RateLimit{Requests: 2, Per: Duration{time.Hour}},
+			PerKey: RateLimit{Requests: 10, Per: Duration{time.Hour}},
+			Total:  RateLimit{Requests: 20, Per: Duration{time.Hour}},
+		}},
+	}
+}
+
+// RateLimitConfigFromEnv reads the limits from RATE_LIMIT, a json-object like the rateLimit-section of the config-file, and the api-keys
+// from RATE_LIMIT_API_KEYS, separated by commas, so they can be set as a secret on their own. config is returned as it was if neither is set.
+func RateLimitConfigFromEnv(config RateLimitConfig) (RateLimitConfig, error) {
+	if value := os.Getenv("RATE_LIMIT"); value != "" {
+		config = RateLimitConfig{}
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return config, errors.New("RATE_LIMIT: " + err.Error())
+		}
+	}
+	if value := os.Getenv("RATE_LIMIT_API_KEYS"); value != "" {
+		config.APIKeys = nil
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				config.APIKeys = append(config.APIKeys, key)
+			}
+		}
+	}
+	return config, nil
+}
+
+func (limit RateLimit) validate() error {
+	if limit.Requests < 0 || limit.Burst < 0 {
+		return errors.New("requests and burst can't be negative")
+	}
+	if limit.Requests > 0 && limit.Per.Duration <= 0 {
+		return errors.New("per must be set when requests is")
+	}
+	return nil
+}
+
+func (config RateLimitConfig) validate() error {
+	if err := config.PerIP.validate(); err != nil {
+		return errors.New("perIP: " + err.Error())
+	}
+	if err := config.PerKey.validate(); err != nil {
+		return errors.New("perKey: " + err.Error())
+	}
+	for _, key := range config.APIKeys {
+		if key == "" {
+			return errors.New("apiKeys can't be empty")
+		}
+	}
+	for i, route := range config.Routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return errors.New("routes[" + strconv.Itoa(i) + "]: path must start with /, like /evaluationtrigger")
+		}
+		for name, limit := range map[string]RateLimit{"perIP": route.PerIP, "perKey": route.PerKey, "total": route.Total} {
+			if err := limit.validate(); err != nil {
+				return errors.New("routes[" + strconv.Itoa(i) + "]." + name + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// apiPath returns the pattern of a route without the /v1 or /exchange in front, so the limits of /evaluationtrigger apply to both.
+func apiPath(pattern string) string {
+	for _, prefix := range []string{"/v1/", "/exchange/"} {
+		if strings.HasPrefix(pattern, prefix) {
+			return pattern[len(prefix)-1:]
+		}
+	}
+	return pattern
+}
+
+// limitsFor returns the limits of the route with pattern, with the defaults filled in.
+func (config RateLimitConfig) limitsFor(pattern string) RouteRateLimit {
+	limits := RouteRateLimit{Path: apiPath(pattern)}
+	for _, route := range config.Routes {
+		if route.Path == limits.Path {
+			limits = route
+		}
+	}
+	if limits.PerIP.Requests == 0 {
+		limits.PerIP = config.PerIP
+	}
+	if limits.PerKey.Requests == 0 {
+		limits.PerKey = config.PerKey
+	}
+	return limits
+}
+
+// tokenBucket holds up to the burst of a RateLimit in tokens, and is refilled with Requests tokens per Per. A request takes a token.
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+func (limit RateLimit) burst() float64 {
+	if limit.Burst > 0 {
+		return float64(limit.Burst)
+	}
+	return float64(limit.Requests)
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: limit.burst(), updated: now}
+}
+
+// refill adds the tokens since the bucket was last refilled.
+func (bucket *tokenBucket) refill(now time.Time) {
+	perSecond := float64(bucket.limit.Requests) / bucket.limit.Per.Seconds()
+	bucket.tokens = math.Min(bucket.limit.burst(), bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+}
+
+// until returns how long it takes until the bucket has tokens.
+func (bucket *tokenBucket) until(tokens float64) time.Duration {
+	if bucket.tokens >= tokens {
+		return 0
+	}
+	perSecond := float64(bucket.limit.Requests) / bucket.limit.Per.Seconds()
+	millis := int64((tokens-bucket.tokens)/perSecond*1000 + 0.5) // Rounded to milliseconds first, so 160.0000001 seconds isn't made 161
+	return time.Duration((millis+999)/1000) * time.Second
+}
+
+// rateDecision - The outcome of a request against the limits. The headers tell about the limit with the fewest requests left.
+type rateDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration // Until the bucket is full again
+	retryAfter time.Duration // Until the next request is allowed, if it wasn't
+}
+
+// RateLimiter is middleware which limits the requests per client and route with token-buckets. Use it with router.Use(limiter.Middleware).
+// The buckets are kept in memory, so the limits are per process.
+type RateLimiter struct {
+	config  RateLimitConfig
+	apiKeys map[string]bool
+	now     func() time.Time
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// maxBuckets is how many buckets are kept before the full ones are removed. A full bucket is the same as a new one, so nothing is lost.
+const maxBuckets = 10000
+
+// NewRateLimiter makes a limiter with config.
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	limiter := &RateLimiter{config: config, apiKeys: map[string]bool{}, now: time.Now, buckets: map[string]*tokenBucket{}}
+	for _, key := range config.APIKeys {
+		limiter.apiKeys[key] = true
+	}
+	return limiter
+}
+
+// clientIP returns the ip-address of the client, from X-Forwarded-For if the proxy is trusted. The last address is used,
+// since that is the one the proxy added, while the ones before it can be anything the client sent.
+func (limiter *RateLimiter) clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); limiter.config.TrustForwardedFor && forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// take takes a token from the bucket of every limit with requests (name is the bucket of the limit), if all of them have one.
+func (limiter *RateLimiter) take(names []string, limits []RateLimit, now time.Time) rateDecision {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if len(limiter.buckets) > maxBuckets {
+		for name, bucket := range limiter.buckets {
+			if bucket.refill(now); bucket.tokens >= bucket.limit.burst() {
+				delete(limiter.buckets, name)
+			}
+		}
+	}
+	decision := rateDecision{allowed: true, remaining: -1}
+	buckets := make([]*tokenBucket, len(limits))
+	for i, limit := range limits {
+		bucket, ok := limiter.buckets[names[i]]
+		if !ok {
+			bucket = newTokenBucket(limit, now)
+			limiter.buckets[names[i]] = bucket
+		}
+		bucket.refill(now)
+		buckets[i] = bucket
+		if bucket.tokens < 1 {
+			decision.allowed = false
+		}
+	}
+	for i, limit := range limits {
+		if decision.allowed {
+			buckets[i].tokens--
+		}
+		remaining := int(buckets[i].tokens)
+		if decision.remaining < 0 || remaining < decision.remaining {
+			decision.limit, decision.remaining = limit.Requests, remaining
+			decision.reset = buckets[i].until(limit.burst())
+		}
+		if wait := buckets[i].until(1); wait > decision.retryAfter {
+			decision.retryAfter = wait
+		}
+	}
+	return decision
+}
+
+// Middleware limits the requests to route. The limits are told in the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and a request over them gets 429 with Retry-After.
+func (limiter *RateLimiter) Middleware(route RouteInfo, handler HandlerFunc) HandlerFunc {
+	limits := limiter.config.limitsFor(route.Pattern)
+	return func(w http.ResponseWriter, r *http.Request, params Params) {
+		client, clientLimit := "ip "+limiter.clientIP(r), limits.PerIP
+		if key := r.Header.Get("X-API-Key"); limiter.apiKeys[key] { // An unknown key is ignored, or anyone could make new ones to get around the limit
+			client, clientLimit = "key "+key, limits.PerKey
+		}
+		names, used := []string{}, []RateLimit{}
+		for name, limit := range map[string]RateLimit{limits.Path + " " + client: clientLimit, limits.Path + " total": limits.Total} {
+			if limit.Requests > 0 {
+				names, used = append(names, name), append(used, limit)
+			}
+		}
+		if len(used) == 0 {
+			handler(w, r, params)
+			return
+		}
+		decision := limiter.take(names, used, limiter.now())
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(decision.limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(int(decision.reset/time.Second)))
+		if !decision.allowed {
+			header.Set("Retry-After", strconv.Itoa(int(decision.retryAfter/time.Second)))
+			writeError(w, http.StatusTooManyRequests, "Too many requests to "+limits.Path+", try again in "+decision.retryAfter.String()+".", nil)
+			return
+		}
+		handler(w, r, params)
+	}
+}
@@ -0,0 +1,119 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "strconv"
+import "strings"
+import "time"
+
+func setupRateLimitedRouter(config RateLimitConfig, now *time.Time) *Router {
+	limiter := NewRateLimiter(config)
+	limiter.now = func() time.Time { return *now }
+	router := NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	for _, prefix := range []string{"/v1", "/exchange"} {
+		router.HandleFunc("GET", prefix+"/latest", ok)
+		router.HandleFunc("GET", prefix+"/evaluationtrigger", ok)
+	}
+	router.Use(limiter.Middleware)
+	return router
+}
+
+func Test_RateLimiter(t *testing.T) {
+	config := DefaultRateLimitConfig()
+	config.PerIP = RateLimit{Requests: 3, Per: Duration{time.Minute}}
+	config.APIKeys = []string{"known"}
+	config.TrustForwardedFor = true
+	now := time.Date(2017, 11, 20, 12, 0, 0, 0, time.UTC)
+	router := setupRateLimitedRouter(config, &now)
+	request := func(path string, ip string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("X-Forwarded-For", "10.0.0.1, "+ip) // The first address is sent by the client, and can't be trusted
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		path       string
+		ip         string
+		key        string
+		statusCode int
+		remaining  string
+	}{
+		{"/v1/latest", "1.1.1.1", "", http.StatusOK, "2"},
+		{"/exchange/latest", "1.1.1.1", "", http.StatusOK, "1"}, // The aliases share the limit
+		{"/v1/latest", "1.1.1.1", "unknown", http.StatusOK, "0"},
+		{"/v1/latest", "1.1.1.1", "", http.StatusTooManyRequests, "0"},
+		{"/v1/latest", "2.2.2.2", "", http.StatusOK, "2"},
+		{"/v1/latest", "1.1.1.1", "known", http.StatusOK, "599"},
+		{"/v1/evaluationtrigger", "1.1.1.1", "", http.StatusOK, "1"}, // Another route has its own limit
+		{"/v1/evaluationtrigger", "1.1.1.1", "", http.StatusOK, "0"},
+		{"/exchange/evaluationtrigger", "1.1.1.1", "", http.StatusTooManyRequests, "0"},
+	}
+	for i, test := range tests {
+		w := request(test.path, test.ip, test.key)
+		if w.Code != test.statusCode || w.Header().Get("RateLimit-Remaining") != test.remaining {
+			t.Error("Request ", i, " to "+test.path+" from "+test.ip+": expected ", test.statusCode, " with "+test.remaining+" remaining, but got ", w.Code, " with ", w.Header())
+		}
+	}
+
+	w := request("/v1/latest", "1.1.1.1", "")
+	apiError := ErrorResponse{}
+	json.Unmarshal(w.Body.Bytes(), &apiError)
+	if apiError.Error.Code != ErrorCodeRateLimited || w.Header().Get("Retry-After") != "20" || w.Header().Get("RateLimit-Limit") != "3" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Error("Expected 429 in the error-envelope with Retry-After and the limit, but got ", w.Header(), " and "+w.Body.String())
+	}
+	now = now.Add(20 * time.Second)
+	if w = request("/v1/latest", "1.1.1.1", ""); w.Code != http.StatusOK {
+		t.Error("Expected a token to be refilled after 20 seconds, but got ", w.Code)
+	}
+
+	for i := 0; i < 18; i++ { // Uses up the total of the evaluation-trigger from many addresses
+		request("/v1/evaluationtrigger", "3.3.3."+strconv.Itoa(i/2), "")
+	}
+	if w = request("/v1/evaluationtrigger", "4.4.4.4", "known"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "160" {
+		t.Error("Expected the total of the route to limit every client, but got ", w.Code, " with ", w.Header())
+	}
+}
+
+func Test_RateLimiter_Unlimited(t *testing.T) {
+	now := time.Now()
+	router := setupRateLimitedRouter(RateLimitConfig{}, &now)
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/evaluationtrigger", nil))
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+			t.Error("Expected no limit when none is configured, but got ", w.Code, " with ", w.Header())
+			return
+		}
+	}
+}
+
+func Test_RateLimitConfigFromEnv(t *testing.T) {
+	defer setupConfigEnv(map[string]string{
+		"MONGODB_URL":         "mongodb://localhost",
+		"RATE_LIMIT":          `{"perIP": {"requests": 10, "per": "1m"}, "routes": [{"path": "/evaluationtrigger", "perIP": {"requests": 1, "per": "24h"}}]}`,
+		"RATE_LIMIT_API_KEYS": "first, second,",
+	})()
+	config, err := LoadConfig()
+	if err != nil {
+		t.Error("Error when loading config: ", err)
+		return
+	}
+	limits := config.RateLimit.limitsFor("/exchange/evaluationtrigger")
+	if limits.PerIP.Requests != 1 || limits.PerIP.Per.Duration != 24*time.Hour || config.RateLimit.limitsFor("/v1/latest").PerIP.Requests != 10 {
+		t.Error("Expected the limits from RATE_LIMIT, but got ", config.RateLimit)
+	}
+	if len(config.RateLimit.APIKeys) != 2 || config.RateLimit.APIKeys[1] != "second" {
+		t.Error("Expected the keys from RATE_LIMIT_API_KEYS, but got ", config.RateLimit.APIKeys)
+	}
+	if logged := config.String(); len(config.Redacted().RateLimit.APIKeys) != 2 || strings.Contains(logged, "first") || strings.Contains(logged, "second") {
+		t.Error("Expected the api-keys to be redacted, but got " + logged)
+	}
+}
@@ -0,0 +1,201 @@
+package exchange
+
+import "encoding/json"
+import "errors"
+import "net/http"
+import "net/url"
+import "strings"
+import "time"
+
+// RateQuery - This is the struct which holds the base-currency and the target-currencies asked for in latest, average and rates.
+// AsOf is a date like 2017-11-20 if the rates as they were on that date are asked for, instead of the latest.
+type RateQuery struct {
+	BaseCurrency     string
+	TargetCurrencies []string
+	AsOf             string
+}
+
+// readRateQuery reads the rate-query from the json-body of a POST (a CurrencyRequest), or from the query of a GET, like
+// ?base=EUR&target=NOK. A GET can ask for several targets, either as &target=NOK&target=SEK or as &target=NOK,SEK.
+// If the request can't be used, the error is written to the user and false is returned.
+func readRateQuery(w http.ResponseWriter, r *http.Request) (RateQuery, bool) {
+	query := RateQuery{}
+	switch r.Method {
+	case "POST":
+		currencyRequest := CurrencyRequest{}
+		decodeErr := json.NewDecoder(r.Body).Decode(&currencyRequest) // Get POST-request
+		if decodeErr != nil {
+			writeError(w, http.StatusBadRequest, "Decoding didn't work on POST-input. "+decodeErr.Error(), nil)
+			return query, false
+		}
+		query.BaseCurrency = currencyRequest.BaseCurrency
+		query.TargetCurrencies = []string{currencyRequest.TargetCurrency}
+		query.AsOf = currencyRequest.AsOf
+		if err := checkAsOf(query.AsOf); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error(), nil)
+			return query, false
+		}
+	case "GET", "HEAD":
+		return readRateQueryValues(w, r.URL.Query(), true)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "We only support GET and POST for this functionality, but you used "+r.Method+".", nil)
+		return query, false
+	}
+	return query, true
+}
+
+// readRateQueryValues reads base, target and asOf from the query of a GET, where target is only required if requireTarget is set.
+func readRateQueryValues(w http.ResponseWriter, values url.Values, requireTarget bool) (RateQuery, bool) {
+	query := RateQuery{BaseCurrency: strings.ToUpper(strings.TrimSpace(values.Get("base"))), AsOf: values.Get("asOf")}
+	for _, value := range values["target"] {
+		for _, target := range strings.Split(value, ",") {
+			if target = strings.ToUpper(strings.TrimSpace(target)); target != "" {
+				query.TargetCurrencies = append(query.TargetCurrencies, target)
+			}
+		}
+	}
+	if query.BaseCurrency == "" || (requireTarget && len(query.TargetCurrencies) == 0) {
+		writeError(w, http.StatusBadRequest, "Both base and target are required, like ?base=EUR&target=NOK", nil)
+		return query, false
+	}
+	for _, currency := range append([]string{query.BaseCurrency}, query.TargetCurrencies...) {
+		if !paramTypes["currency"].MatchString(currency) {
+			writeError(w, http.StatusBadRequest, "'"+currency+"' is not a currency-code, like EUR", nil)
+			return query, false
+		}
+	}
+	if err := checkAsOf(query.AsOf); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return query, false
+	}
+	return query, true
+}
+
+// checkAsOf checks that asOf is empty, or a date like 2017-11-20 which isn't in the future.
+func checkAsOf(asOf string) error {
+	if asOf == "" {
+		return nil
+	}
+	date, err := time.Parse("2006-01-02", asOf)
+	if err != nil {
+		return errors.New("'" + asOf + "' is not a date, like 2017-11-20")
+	}
+	if date.After(time.Now().UTC()) {
+		return errors.New("There are no rates for " + asOf + " yet, it is in the future")
+	}
+	return nil
+}
+
+// snapshotFor returns the latest snapshot for the base-currency of query, or the one in effect on query.AsOf if it is set.
+func snapshotFor(query RateQuery) (Currency, int, error) {
+	if query.AsOf == "" {
+		return DB.GetLatestCurrency(query.BaseCurrency)
+	}
+	return DB.GetCurrencyAsOf(query.BaseCurrency, query.AsOf)
+}
+
+// RatesResponse - This is the struct which is sent back for rates. Date is the date of the snapshot, while AsOf is the date that was asked for, if any.
+// They differ when the date asked for was a weekend or a holiday, and the last business day before it was used.
+type RatesResponse struct {
+	Currency
+	AsOf string `json:"asOf,omitempty"`
+}
+
+// GetRates answers with the latest snapshot for ?base=EUR, with all its rates and its date, in one response.
+// With &target=NOK,SEK (or &target=NOK&target=SEK) only those rates are included, and with &asOf=2017-11-20 the snapshot in effect on that date is used.
+func GetRates(w http.ResponseWriter, r *http.Request) {
+	query, ok := readRateQueryValues(w, r.URL.Query(), false)
+	if !ok {
+		return
+	}
+	writeSnapshot(w, r, query)
+}
+
+// GetRatesAsOf answers like GetRates, but with the snapshot in effect on the date in the path, like /v1/rates/2017-11-20?base=EUR.
+func GetRatesAsOf(w http.ResponseWriter, r *http.Request, params Params) {
+	values := r.URL.Query()
+	values.Set("asOf", params["date"])
+	query, ok := readRateQueryValues(w, values, false)
+	if !ok {
+		return
+	}
+	writeSnapshot(w, r, query)
+}
+
+// writeSnapshot writes the snapshot for query, with only the targets asked for, in the format asked for (see negotiateFormat).
+func writeSnapshot(w http.ResponseWriter, r *http.Request, query RateQuery) {
+	w.Header().Add("Vary", "Accept")
+	format, statusCode, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, statusCode, err.Error(), nil)
+		return
+	}
+	currency, statusCode, err := snapshotFor(query)
+	if err != nil {
+		writeError(w, statusCode, "We got an error while getting the currency: "+err.Error(), nil)
+		return
+	}
+	rates, statusCode, err := selectRates(currency, query.TargetCurrencies)
+	if err != nil {
+		writeError(w, statusCode, err.Error(), nil)
+		return
+	}
+	if notModified(w, r, rates.Date, format, time.Now()) {
+		return
+	}
+	switch format {
+	case FormatCSV:
+		writeRatesCSV(w, snapshotRates(rates, query.AsOf))
+	case FormatXML:
+		writeRatesXML(w, snapshotRates(rates, query.AsOf))
+	default:
+		writeJSON(w, http.StatusOK, &RatesResponse{rates, query.AsOf})
+	}
+}
+
+// selectRates returns the snapshot with only the targets in it, or all of them if no targets are given.
+func selectRates(currency Currency, targets []string) (Currency, int, error) {
+	if len(targets) == 0 {
+		return currency, http.StatusOK, nil
+	}
+	selected := Currency{Base: currency.Base, Date: currency.Date, Rates: map[string]float32{}}
+	for _, target := range targets {
+		rate, ok := currency.Rates[target]
+		if !ok {
+			return selected, http.StatusBadRequest, errors.New("TargetCurrency " + target + " not an accepted rate")
+		}
+		selected.Rates[target] = rate
+	}
+	return selected, http.StatusOK, nil
+}
+
+// writeRates writes rates in the format asked for (see negotiateFormat), where json is written like writeRatesJSON.
+// A GET gets the caching-headers from notModified, and 304 if it already has the rates.
+func writeRates(w http.ResponseWriter, r *http.Request, rates []RateResponse) {
+	w.Header().Add("Vary", "Accept")
+	format, statusCode, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, statusCode, err.Error(), nil)
+		return
+	}
+	if len(rates) > 0 && notModified(w, r, rates[0].Date, format, time.Now()) { // The rates are all from the same snapshot
+		return
+	}
+	switch format {
+	case FormatCSV:
+		writeRatesCSV(w, rates)
+	case FormatXML:
+		writeRatesXML(w, rates)
+	default:
+		writeRatesJSON(w, rates)
+	}
+}
+
+// writeRatesJSON writes one rate as an object, like the POST-variant always has, and several rates as a list in the order they were asked for.
+func writeRatesJSON(w http.ResponseWriter, rates []RateResponse) {
+	if len(rates) == 1 {
+		writeJSON(w, http.StatusOK, &rates[0])
+		return
+	}
+	writeJSON(w, http.StatusOK, &rates)
+}
@@ -0,0 +1,177 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+
+func Test_Handler_LatestAndAverageGET(t *testing.T) {
+	db := setupValidationDatabase()
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.7, "SEK": 10}})
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-22", map[string]float32{"NOK": 9.8, "SEK": 10.1}})
+	DB = db
+	router := NewAPIRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/exchange/latest?base=EUR&target=NOK", nil))
+	latest := RateResponse{}
+	json.NewDecoder(w.Body).Decode(&latest)
+	if w.Code != http.StatusOK || latest != (RateResponse{"EUR", "NOK", 9.8, "2017-11-22", ""}) {
+		t.Error("Expected one target to be answered as an object, but got ", w.Code, " and ", latest)
+	}
+
+	for _, path := range []string{"/v1/latest?base=eur&target=SEK,nok", "/v1/latest?base=EUR&target=SEK&target=NOK"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		rates := []RateResponse{}
+		json.NewDecoder(w.Body).Decode(&rates)
+		if w.Code != http.StatusOK || len(rates) != 2 || rates[0] != (RateResponse{"EUR", "SEK", 10.1, "2017-11-22", ""}) || rates[1].TargetCurrency != "NOK" {
+			t.Error("GET "+path+": expected both targets in the order asked for, but got ", w.Code, " and ", rates)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/average?base=EUR&target=NOK,SEK", nil))
+	averages := []RateResponse{}
+	json.NewDecoder(w.Body).Decode(&averages)
+	if w.Code != http.StatusOK || len(averages) != 2 || averages[0].Rate < 9.69 || averages[0].Rate > 9.71 || averages[1].Rate < 9.99 || averages[1].Rate > 10.01 {
+		t.Error("Expected the average of both targets, but got ", w.Code, " and ", averages)
+	}
+
+	for _, path := range []string{"/v1/latest?base=EUR", "/v1/latest?target=NOK", "/v1/latest?base=EUR&target=NOK,X1", "/v1/latest?base=EUR&target=XYZ"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Error("GET "+path+": expected statuscode 400, but got ", w.Code)
+		}
+	}
+	for _, path := range []string{"/v1/latest?base=USD&target=NOK", "/v1/average?base=USD&target=NOK"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Error("GET "+path+": expected statuscode 404 when nothing is stored for the base, but got ", w.Code)
+		}
+	}
+}
+
+func Test_Handler_GetRates(t *testing.T) {
+	db := setupValidationDatabase()
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.7, "SEK": 10, "USD": 1.2}})
+	DB = db
+	router := NewAPIRouter()
+
+	tests := []struct {
+		path  string
+		rates map[string]float32
+	}{
+		{"/v1/rates?base=EUR", map[string]float32{"NOK": 9.7, "SEK": 10, "USD": 1.2}},
+		{"/exchange/rates?base=EUR&target=NOK,USD", map[string]float32{"NOK": 9.7, "USD": 1.2}},
+		{"/v1/rates?base=eur&target=sek", map[string]float32{"SEK": 10}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		snapshot := Currency{}
+		json.NewDecoder(w.Body).Decode(&snapshot)
+		if w.Code != http.StatusOK || snapshot.Base != "EUR" || snapshot.Date != "2017-11-21" || len(snapshot.Rates) != len(test.rates) {
+			t.Error("GET "+test.path+": expected the snapshot from 2017-11-21 with ", test.rates, ", but got ", w.Code, " and ", snapshot)
+			continue
+		}
+		for target, rate := range test.rates {
+			if snapshot.Rates[target] != rate {
+				t.Error("GET "+test.path+": expected "+target+" to be ", rate, ", but got ", snapshot.Rates[target])
+			}
+		}
+	}
+
+	for _, path := range []string{"/v1/rates", "/v1/rates?base=EUR&target=XYZ"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Error("GET "+path+": expected statuscode 400, but got ", w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/rates?base=USD", nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("GET /v1/rates?base=USD: expected statuscode 404, but got ", w.Code)
+	}
+}
+
+func Test_Handler_RatesAsOf(t *testing.T) {
+	db := &memoryStorage{}
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-16", map[string]float32{"NOK": 9.5, "SEK": 9.8}})
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-17", map[string]float32{"NOK": 9.6, "SEK": 9.9}}) // Friday
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.7, "SEK": 10}})  // Monday
+	DB = db
+	router := NewAPIRouter()
+
+	tests := []struct {
+		path string
+		date string
+		nok  float32
+	}{
+		{"/v1/rates/2017-11-17?base=EUR", "2017-11-17", 9.6},
+		{"/exchange/rates/2017-11-18?base=EUR", "2017-11-17", 9.6}, // Saturday, so Friday is used
+		{"/v1/rates/2017-11-19?base=EUR&target=NOK", "2017-11-17", 9.6},
+		{"/v1/rates?base=EUR&asOf=2017-11-16", "2017-11-16", 9.5},
+		{"/v1/rates/2017-11-21?base=EUR", "2017-11-20", 9.7},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		snapshot := RatesResponse{}
+		json.NewDecoder(w.Body).Decode(&snapshot)
+		if w.Code != http.StatusOK || snapshot.Date != test.date || snapshot.AsOf == "" || snapshot.Rates["NOK"] != test.nok {
+			t.Error("GET "+test.path+": expected the snapshot from "+test.date+", but got ", w.Code, " and ", snapshot)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/latest?base=EUR&target=SEK&asOf=2017-11-19", nil))
+	latest := RateResponse{}
+	json.NewDecoder(w.Body).Decode(&latest)
+	if w.Code != http.StatusOK || latest != (RateResponse{"EUR", "SEK", 9.9, "2017-11-17", "2017-11-19"}) {
+		t.Error("Expected latest with asOf to use the last business day before it, but got ", w.Code, " and ", latest)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/latest", strings.NewReader(`{"baseCurrency": "EUR", "targetCurrency": "NOK", "asOf": "2017-11-16"}`)))
+	latest = RateResponse{}
+	json.NewDecoder(w.Body).Decode(&latest)
+	if w.Code != http.StatusOK || latest.Rate != 9.5 || latest.Date != "2017-11-16" {
+		t.Error("Expected POST latest with asOf to use that date, but got ", w.Code, " and ", latest)
+	}
+
+	for _, request := range []*http.Request{
+		httptest.NewRequest("GET", "/v1/average?base=EUR&target=NOK&asOf=2017-11-17", nil),
+		httptest.NewRequest("POST", "/v1/average", strings.NewReader(`{"baseCurrency": "EUR", "targetCurrency": "NOK", "asOf": "2017-11-17"}`)),
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		apiError := ErrorResponse{}
+		json.NewDecoder(w.Body).Decode(&apiError)
+		if w.Code != http.StatusBadRequest || apiError.Error.Code != ErrorCodeValidation {
+			t.Error(request.Method+" /v1/average with asOf: expected 400 validation_failed, since the average can't be for another date, but got ", w.Code, " and ", apiError)
+		}
+	}
+
+	errorTests := []struct {
+		path       string
+		statusCode int
+	}{
+		{"/v1/rates/2017-11-15?base=EUR", http.StatusNotFound},
+		{"/v1/rates/2017-13-45?base=EUR", http.StatusBadRequest},
+		{"/v1/rates/2999-01-01?base=EUR", http.StatusBadRequest},
+		{"/v1/latest?base=EUR&target=NOK&asOf=yesterday", http.StatusBadRequest},
+		{"/v1/rates/yesterday?base=EUR", http.StatusNotFound},
+	}
+	for _, test := range errorTests {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.statusCode {
+			t.Error("GET "+test.path+": expected statuscode ", test.statusCode, ", but got ", w.Code)
+		}
+	}
+}
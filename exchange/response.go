@@ -0,0 +1,70 @@
+package exchange
+
+import "encoding/json"
+import "net/http"
+
+// APIError - This is the struct which describes what went wrong in a request. Code is meant for programs, and doesn't change,
+// while Message is meant for people. Details is extra information, like the field-errors when a webhook didn't validate.
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ErrorResponse - Every error from the api is sent as {"error": {"code": "...", "message": "...", "details": ...}}.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// The codes used in the error-envelope.
+const (
+	ErrorCodeBadRequest       = "bad_request"
+	ErrorCodeValidation       = "validation_failed"
+	ErrorCodeNotFound         = "not_found"
+	ErrorCodeMethodNotAllowed = "method_not_allowed"
+	ErrorCodeNotAcceptable    = "not_acceptable"
+	ErrorCodeConflict         = "conflict"
+	ErrorCodeDeliveryFailed   = "delivery_failed"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeInternal         = "internal_error"
+	ErrorCodeNotImplemented   = "not_implemented"
+)
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          ErrorCodeBadRequest,
+	http.StatusNotFound:            ErrorCodeNotFound,
+	http.StatusMethodNotAllowed:    ErrorCodeMethodNotAllowed,
+	http.StatusNotAcceptable:       ErrorCodeNotAcceptable,
+	http.StatusConflict:            ErrorCodeConflict,
+	http.StatusExpectationFailed:   ErrorCodeDeliveryFailed,
+	http.StatusTooManyRequests:     ErrorCodeRateLimited,
+	http.StatusInternalServerError: ErrorCodeInternal,
+	http.StatusNotImplemented:      ErrorCodeNotImplemented,
+}
+
+// ErrorCode returns the code in the error-envelope for a statuscode.
+func ErrorCode(statusCode int) string {
+	if code, ok := errorCodes[statusCode]; ok {
+		return code
+	}
+	if statusCode >= 500 {
+		return ErrorCodeInternal
+	}
+	return ErrorCodeBadRequest
+}
+
+// writeJSON writes value to the user as json with statuscode.
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes message to the user in the error-envelope, with the code for statuscode.
+// Statuscodes below 400 are made 500, since the storage sometimes returns them together with an error.
+func writeError(w http.ResponseWriter, statusCode int, message string, details interface{}) {
+	if statusCode < 400 {
+		statusCode = http.StatusInternalServerError
+	}
+	writeJSON(w, statusCode, &ErrorResponse{APIError{ErrorCode(statusCode), message, details}})
+}
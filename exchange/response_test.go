@@ -0,0 +1,63 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+
+func Test_Handler_ErrorEnvelope(t *testing.T) {
+	DB = setupValidationDatabase()
+	router := NewAPIRouter()
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		statusCode int
+		code       string
+	}{
+		{"GET", "/v1/webhooks/404", "", http.StatusNotFound, ErrorCodeNotFound},
+		{"DELETE", "/exchange/404", "", http.StatusNotFound, ErrorCodeNotFound},
+		{"POST", "/v1/webhooks/404/pause", "", http.StatusNotFound, ErrorCodeNotFound},
+		{"POST", "/v1/latest", "{", http.StatusBadRequest, ErrorCodeBadRequest},
+		{"POST", "/v1/latest", `{"baseCurrency": "EUR", "targetCurrency": "XYZ"}`, http.StatusBadRequest, ErrorCodeBadRequest},
+		{"PUT", "/v1/latest", "", http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed},
+		{"GET", "/v1/nothing", "", http.StatusNotFound, ErrorCodeNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+		response := ErrorResponse{}
+		err := json.NewDecoder(w.Body).Decode(&response)
+		if w.Code != test.statusCode || err != nil || response.Error.Code != test.code || response.Error.Message == "" {
+			t.Error(test.method+" "+test.path+": expected statuscode ", test.statusCode, " and code "+test.code+", but got ", w.Code, " and ", response, err)
+		}
+		if contentType := w.Header().Get("content-type"); contentType != "application/json" {
+			t.Error(test.method + " " + test.path + ": expected the error as json, but got content-type " + contentType)
+		}
+	}
+}
+
+func Test_Handler_LatestAndAverageJSON(t *testing.T) {
+	db := setupValidationDatabase()
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-21", map[string]float32{"NOK": 9.7}})
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-22", map[string]float32{"NOK": 9.8}})
+	DB = db
+
+	w := httptest.NewRecorder()
+	GetLatest(w, httptest.NewRequest("POST", "/v1/latest", strings.NewReader(`{"baseCurrency": "EUR", "targetCurrency": "NOK"}`)))
+	latest := RateResponse{}
+	json.NewDecoder(w.Body).Decode(&latest)
+	if w.Code != http.StatusOK || latest != (RateResponse{"EUR", "NOK", 9.8, "2017-11-22", ""}) {
+		t.Error("Expected the latest rate as json with statuscode 200, but got ", w.Code, " and ", latest)
+	}
+
+	w = httptest.NewRecorder()
+	GetAverage(w, httptest.NewRequest("POST", "/v1/average", strings.NewReader(`{"baseCurrency": "EUR", "targetCurrency": "NOK"}`)))
+	average := RateResponse{}
+	json.NewDecoder(w.Body).Decode(&average)
+	if w.Code != http.StatusOK || average.Rate < 9.69 || average.Rate > 9.71 || average.Date != "" {
+		t.Error("Expected the average rate as json with statuscode 200, but got ", w.Code, " and ", average)
+	}
+}
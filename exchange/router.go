@@ -0,0 +1,217 @@
+package exchange
+
+import "net/http"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+// Params holds the path-parameters of a request, like "id" in /v1/webhooks/{id}.
+// They are already checked against their type when the handler gets them.
+type Params map[string]string
+
+// Date returns a date-parameter, like {date:date}.
+func (params Params) Date(name string) time.Time {
+	date, _ := time.Parse("2006-01-02", params[name])
+	return date
+}
+
+// Int returns an int-parameter, like {count:int}.
+func (params Params) Int(name string) int {
+	value, _ := strconv.Atoi(params[name])
+	return value
+}
+
+// HandlerFunc is a handler which gets the path-parameters of the route.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, params Params)
+
+// The types a path-parameter can have, written like {name:type}. {name} can be any segment.
+var paramTypes = map[string]*regexp.Regexp{
+	"":         regexp.MustCompile(`^.+$`),
+	"currency": regexp.MustCompile(`^[A-Z]{3}$`),
+	"date":     regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"int":      regexp.MustCompile(`^\d+$`),
+}
+
+type segment struct {
+	literal   string // Empty for parameters
+	param     string
+	paramType *regexp.Regexp
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  HandlerFunc
+}
+
+// Middleware wraps the handler of a route. It gets the route, so it can act differently per route, like a stricter rate-limit.
+type Middleware func(route RouteInfo, handler HandlerFunc) HandlerFunc
+
+// Router sends requests to the handler of the route with the same method and a matching path.
+// Literal segments are preferred over parameters, so /exchange/latest wins over /exchange/{id}.
+type Router struct {
+	routes     []route
+	middleware []Middleware
+}
+
+// NewRouter makes a router without routes.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle adds a route. It panics if the pattern has a parameter with an unknown type, since that is a programming error.
+func (router *Router) Handle(method string, pattern string, handler HandlerFunc) {
+	newRoute := route{method: strings.ToUpper(method), pattern: pattern, handler: handler}
+	for _, part := range splitPath(pattern) {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			newRoute.segments = append(newRoute.segments, segment{literal: part})
+			continue
+		}
+		nameAndType := strings.SplitN(part[1:len(part)-1], ":", 2)
+		paramType := ""
+		if len(nameAndType) == 2 {
+			paramType = nameAndType[1]
+		}
+		regex, ok := paramTypes[paramType]
+		if !ok {
+			panic("Unknown type of path-parameter in " + pattern + ": " + paramType)
+		}
+		newRoute.segments = append(newRoute.segments, segment{param: nameAndType[0], paramType: regex})
+	}
+	router.routes = append(router.routes, newRoute)
+}
+
+// Use adds middleware around the handlers of every route, also the ones added later. The first one added is the outermost.
+// Requests which don't match a route (404 and 405) don't go through the middleware.
+func (router *Router) Use(middleware Middleware) {
+	router.middleware = append(router.middleware, middleware)
+}
+
+// HandleFunc adds a route to a handler without path-parameters.
+func (router *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc) {
+	router.Handle(method, pattern, func(w http.ResponseWriter, r *http.Request, params Params) { handler(w, r) })
+}
+
+// splitPath splits a path in segments, so /exchange/, /exchange and exchange are the same.
+func splitPath(path string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// match returns the parameters and how many literal segments matched, or false if the path doesn't match.
+func (route route) match(parts []string) (Params, int, bool) {
+	if len(parts) != len(route.segments) {
+		return nil, 0, false
+	}
+	params := Params{}
+	literals := 0
+	for i, segment := range route.segments {
+		if segment.paramType == nil {
+			if segment.literal != parts[i] {
+				return nil, 0, false
+			}
+			literals++
+		} else if segment.paramType.MatchString(parts[i]) {
+			params[segment.param] = parts[i]
+		} else {
+			return nil, 0, false
+		}
+	}
+	return params, literals, true
+}
+
+// RouteInfo - The method and pattern of a route, like GET and /v1/webhooks/{id}.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+}
+
+// Routes returns the routes in the order they were added.
+func (router *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(router.routes))
+	for i, route := range router.routes {
+		routes[i] = RouteInfo{route.method, route.pattern}
+	}
+	return routes
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := splitPath(r.URL.Path)
+	method := strings.ToUpper(r.Method)
+	type candidate struct {
+		route    route
+		params   Params
+		literals int
+	}
+	candidates := []candidate{}
+	for _, route := range router.routes {
+		if params, literals, ok := route.match(parts); ok {
+			candidates = append(candidates, candidate{route, params, literals})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].literals > candidates[j].literals })
+
+	allowed := []string{}
+	for _, candidate := range candidates {
+		if candidate.literals != candidates[0].literals {
+			break // A less specific path, ie. /exchange/{id} when /exchange/latest matched
+		}
+		if candidate.route.method == method || (method == "HEAD" && candidate.route.method == "GET") {
+			handler := candidate.route.handler
+			for i := len(router.middleware) - 1; i >= 0; i-- {
+				handler = router.middleware[i](RouteInfo{candidate.route.method, candidate.route.pattern}, handler)
+			}
+			handler(w, r, candidate.params)
+			return
+		}
+		allowed = append(allowed, candidate.route.method)
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "We only support "+strings.Join(allowed, " and ")+" for this functionality, but you used "+r.Method+".", nil)
+		return
+	}
+	writeError(w, http.StatusNotFound, "We don't have anything at "+r.URL.Path, nil)
+}
+
+// withID adapts a handler which gets the webhook-id as an argument.
+func withID(handler func(http.ResponseWriter, *http.Request, string)) HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params Params) {
+		handler(w, r, params["id"])
+	}
+}
+
+// NewAPIRouter makes the router with the /v1 api, and the old /exchange paths as aliases for the same handlers.
+func NewAPIRouter() *Router {
+	router := NewRouter()
+	for _, prefix := range []string{"/v1/webhooks", "/exchange"} {
+		router.HandleFunc("POST", prefix, CreateWebhook)
+		router.Handle("GET", prefix+"/{id}", withID(GetWebhookHandler))
+		router.Handle("DELETE", prefix+"/{id}", withID(DeleteWebhookHandler))
+		router.Handle("POST", prefix+"/{id}/test", withID(TestWebhook))
+		router.Handle("POST", prefix+"/{id}/verify", withID(VerifyWebhookHandler))
+		router.Handle("POST", prefix+"/{id}/pause", withID(PauseWebhook))
+		router.Handle("POST", prefix+"/{id}/resume", withID(ResumeWebhook))
+	}
+	for _, prefix := range []string{"/v1", "/exchange"} {
+		router.HandleFunc("GET", prefix+"/latest", GetLatest)
+		router.HandleFunc("POST", prefix+"/latest", GetLatest)
+		router.HandleFunc("GET", prefix+"/average", GetAverage)
+		router.HandleFunc("GET", prefix+"/rates", GetRates)
+		router.Handle("GET", prefix+"/rates/{date:date}", GetRatesAsOf)
+		router.HandleFunc("POST", prefix+"/average", GetAverage)
+		router.HandleFunc("POST", prefix+"/bot/latest", BotGetLatest)
+		router.HandleFunc("GET", prefix+"/evaluationtrigger", EvaluationTrigger)
+	}
+	router.HandleFunc("GET", "/openapi.json", ServeOpenAPI)
+	router.HandleFunc("GET", "/docs", ServeDocs)
+	return router
+}
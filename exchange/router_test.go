@@ -0,0 +1,113 @@
+package exchange
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+
+func Test_Router(t *testing.T) {
+	router := NewRouter()
+	handled := ""
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, params Params) {
+			handled = name + " " + params["id"] + params["base"] + params["date"]
+		}
+	}
+	router.Handle("GET", "/items/{id}", handler("get"))
+	router.Handle("DELETE", "/items/{id}", handler("delete"))
+	router.Handle("POST", "/items/latest", handler("latest"))
+	router.Handle("GET", "/rates/{base:currency}/{date:date}", handler("rates"))
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		handled    string
+	}{
+		{"GET", "/items/42", http.StatusOK, "get 42"},
+		{"GET", "/items/42/", http.StatusOK, "get 42"}, // A trailing slash is the same path
+		{"delete", "/items/42", http.StatusOK, "delete 42"},
+		{"HEAD", "/items/42", http.StatusOK, "get 42"},
+		{"POST", "/items/latest", http.StatusOK, "latest "},
+		{"GET", "/items/latest", http.StatusMethodNotAllowed, ""}, // The literal path wins over {id}
+		{"POST", "/items/42", http.StatusMethodNotAllowed, ""},
+		{"GET", "/rates/EUR/2017-11-20", http.StatusOK, "rates EUR2017-11-20"},
+		{"GET", "/rates/euro/2017-11-20", http.StatusNotFound, ""},
+		{"GET", "/rates/EUR/yesterday", http.StatusNotFound, ""},
+		{"GET", "/items", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		handled = ""
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.statusCode || handled != test.handled {
+			t.Error(test.method+" "+test.path+": expected statuscode ", test.statusCode, " and '"+test.handled+"', but got ", w.Code, " and '"+handled+"'")
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/items/42", nil))
+	if allow := w.Header().Get("Allow"); allow != "GET, DELETE" {
+		t.Error("Expected the Allow-header to list GET and DELETE, but got " + allow)
+	}
+}
+
+func Test_Router_Middleware(t *testing.T) {
+	router := NewRouter()
+	calls := []string{}
+	trace := func(name string) Middleware {
+		return func(route RouteInfo, handler HandlerFunc) HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request, params Params) {
+				calls = append(calls, name+" "+route.Method+" "+route.Pattern)
+				handler(w, r, params)
+			}
+		}
+	}
+	router.Use(trace("outer"))
+	router.Use(trace("inner"))
+	router.HandleFunc("GET", "/v1/latest", func(w http.ResponseWriter, r *http.Request) { calls = append(calls, "handler") })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/latest", nil))
+	if strings.Join(calls, ", ") != "outer GET /v1/latest, inner GET /v1/latest, handler" {
+		t.Error("Expected the middleware in the order it was added, also for routes added after, but got ", calls)
+	}
+	calls = nil
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/unknown", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/latest", nil))
+	if len(calls) != 0 {
+		t.Error("Expected 404 and 405 not to go through the middleware, but got ", calls)
+	}
+}
+
+func Test_APIRouter_V1AndLegacy(t *testing.T) {
+	db := &memoryStorage{}
+	id, _, _ := db.RegisterWebhookToDatabase(Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1, Status: WebhookActive})
+	DB = db
+	router := NewAPIRouter()
+
+	for _, path := range []string{"/v1/webhooks/" + id, "/exchange/" + id, "/exchange/" + id + "/"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"`+id+`"`) {
+			t.Error("GET "+path+": expected the webhook, but got ", w.Code, ": ", w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/webhooks/"+id+"/pause", nil))
+	if webhook, _, _ := db.GetWebhook(id); w.Code != http.StatusOK || webhook.Status != WebhookPaused {
+		t.Error("Expected POST /v1/webhooks/{id}/pause to pause the webhook, got ", w.Code, " and "+webhook.Status)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/webhooks/"+id, nil))
+	if _, _, err := db.GetWebhook(id); w.Code != http.StatusNoContent || err == nil {
+		t.Error("Expected DELETE /v1/webhooks/{id} to delete the webhook, got ", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/v1/webhooks", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("Expected 405 for PUT /v1/webhooks, got ", w.Code)
+	}
+}
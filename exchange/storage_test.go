@@ -0,0 +1,200 @@
+package exchange
+
+import "errors"
+import "net/http"
+import "strconv"
+import "sort"
+import "gopkg.in/mgo.v2/bson"
+
+// memoryStorage is a Storage kept in memory, so handlers can be tested without a running MongoDB.
+type memoryStorage struct {
+	webhooks   []Webhook
+	currencies []Currency
+	nextID     int
+	revision   int // Counts the changes to currencies, like CurrencyRevision does for MongoDB
+}
+
+func (db *memoryStorage) Init() {}
+
+func (db *memoryStorage) RegisterWebhookToDatabase(webhook Webhook) (string, int, error) {
+	db.nextID++
+	webhook.ID = strconv.Itoa(db.nextID)
+	db.webhooks = append(db.webhooks, webhook)
+	return webhook.ID, 201, nil
+}
+
+func (db *memoryStorage) GetWebhook(id string) (Webhook, int, error) {
+	for _, webhook := range db.webhooks {
+		if webhook.ID == id {
+			return webhook, http.StatusOK, nil
+		}
+	}
+	return Webhook{}, http.StatusNotFound, errors.New("not found")
+}
+
+func (db *memoryStorage) UpdateWebhook(webhook Webhook) (int, error) {
+	for i := range db.webhooks {
+		if db.webhooks[i].ID == webhook.ID {
+			db.webhooks[i] = webhook
+			return http.StatusOK, nil
+		}
+	}
+	return http.StatusNotFound, errors.New("not found")
+}
+
+func (db *memoryStorage) SetWebhookStatus(id string, from string, status string) (Webhook, int, error) {
+	for i := range db.webhooks {
+		if db.webhooks[i].ID != id {
+			continue
+		}
+		if !hasStatus(db.webhooks[i], statusIn(from)) {
+			return Webhook{}, http.StatusConflict, errors.New("changed meanwhile")
+		}
+		db.webhooks[i].Status, db.webhooks[i].Failures = status, 0
+		return db.webhooks[i], http.StatusOK, nil
+	}
+	return Webhook{}, http.StatusNotFound, errors.New("not found")
+}
+
+func (db *memoryStorage) RecordWebhookDelivery(id string, failed bool, digestSentAt string, maxFailures int) (Webhook, bool, int, error) {
+	for i := range db.webhooks {
+		webhook := &db.webhooks[i]
+		if webhook.ID != id {
+			continue
+		}
+		if !failed {
+			webhook.Failures = 0
+			if digestSentAt != "" {
+				webhook.DigestSentAt = digestSentAt
+			}
+			return *webhook, false, http.StatusOK, nil
+		}
+		webhook.Failures++
+		if webhook.Failures < maxFailures || !hasStatus(*webhook, statusIn("", WebhookActive)) {
+			return *webhook, false, http.StatusOK, nil
+		}
+		webhook.Status = WebhookDisabled
+		return *webhook, true, http.StatusOK, nil
+	}
+	return Webhook{}, false, http.StatusNotFound, errors.New("not found")
+}
+
+// hasStatus tells if webhook matches filter from statusIn like it would in MongoDB. A webhook without a status is taken as one
+// stored before webhooks had a status, which doesn't have the field at all.
+func hasStatus(webhook Webhook, filter bson.M) bool {
+	var stored interface{}
+	if webhook.Status != "" {
+		stored = webhook.Status
+	}
+	for _, value := range filter["$in"].([]interface{}) {
+		if value == stored {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *memoryStorage) DeleteWebhook(id string) (int, error) {
+	for i, webhook := range db.webhooks {
+		if webhook.ID == id {
+			db.webhooks = append(db.webhooks[:i], db.webhooks[i+1:]...)
+			return http.StatusOK, nil
+		}
+	}
+	return http.StatusNotFound, errors.New("not found")
+}
+
+func (db *memoryStorage) GetLatest(baseCurrency string, targetCurrency string) (float32, string, int, error) {
+	latestCurrency, statusCode, err := db.GetLatestCurrency(baseCurrency)
+	if err != nil {
+		return -1, "", statusCode, err
+	}
+	val, ok := latestCurrency.Rates[targetCurrency]
+	if !ok {
+		return -1, "", http.StatusBadRequest, errors.New("TargetCurrency not an accepted rate")
+	}
+	return val, latestCurrency.Date, http.StatusOK, nil
+}
+
+func (db *memoryStorage) GetLatestCurrency(baseCurrency string) (Currency, int, error) {
+	currencies := db.byBase(baseCurrency)
+	if len(currencies) == 0 {
+		return Currency{}, http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency)
+	}
+	return currencies[len(currencies)-1], http.StatusOK, nil
+}
+
+func (db *memoryStorage) GetCurrencyAsOf(baseCurrency string, date string) (Currency, int, error) {
+	currencies := db.byBase(baseCurrency)
+	for i := len(currencies) - 1; i >= 0; i-- {
+		if currencies[i].Date <= date {
+			return currencies[i], http.StatusOK, nil
+		}
+	}
+	return Currency{}, http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency + " on or before " + date)
+}
+
+func (db *memoryStorage) GetCurrencies(baseCurrency string, count int) ([]Currency, int, error) {
+	currencies := db.byBase(baseCurrency)
+	if len(currencies) == 0 {
+		return currencies, http.StatusNotFound, errors.New("No stored currencies with base " + baseCurrency)
+	}
+	latest := []Currency{}
+	for i := len(currencies) - 1; i >= 0 && len(latest) < count; i-- {
+		latest = append(latest, currencies[i])
+	}
+	return latest, http.StatusOK, nil
+}
+
+func (db *memoryStorage) GetAverage(baseCurrency string, targetCurrency string) (float32, int, error) {
+	currencies := db.byBase(baseCurrency)
+	if len(currencies) < 3 {
+		return -1, http.StatusNotFound, errors.New("not enough currencies")
+	}
+	var total float32
+	for _, currency := range currencies[len(currencies)-3:] {
+		val, ok := currency.Rates[targetCurrency]
+		if !ok {
+			return -1, http.StatusBadRequest, errors.New("TargetCurrency not an accepted rate")
+		}
+		total += val
+	}
+	return total / 3, http.StatusOK, nil
+}
+
+func (db *memoryStorage) RegisterCurrencyToDatabase(currency Currency) (int, error) {
+	db.currencies = append(db.currencies, currency)
+	db.revision++
+	return 201, nil
+}
+
+func (db *memoryStorage) CurrencyRevision() (string, int, error) {
+	return strconv.Itoa(db.revision), http.StatusOK, nil
+}
+
+func (db *memoryStorage) GetAllWebhooks() ([]Webhook, int, error) {
+	return append([]Webhook{}, db.webhooks...), 200, nil
+}
+
+func (db *memoryStorage) ResetWebhook() bool {
+	db.webhooks = nil
+	return true
+}
+
+func (db *memoryStorage) ResetCurrency() bool {
+	db.currencies = nil
+	db.revision++
+	return true
+}
+
+// byBase returns the currencies with base baseCurrency sorted by date.
+func (db *memoryStorage) byBase(baseCurrency string) []Currency {
+	currencies := []Currency{}
+	for _, currency := range db.currencies {
+		if currency.Base == baseCurrency {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.SliceStable(currencies, func(i, j int) bool { return currencies[i].Date < currencies[j].Date })
+	return currencies
+}
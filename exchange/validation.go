@@ -0,0 +1,121 @@
+package exchange
+
+import "net/http"
+import "net/mail"
+import "net/url"
+import "strings"
+import "time"
+
+// FieldError - This is the struct which describes what is wrong with one field in a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors - A list of field-errors, returned to the user as json.
+type ValidationErrors []FieldError
+
+// Error makes ValidationErrors usable as an error.
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, fieldError := range errs {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Write writes the field-errors to the user in the error-envelope, as the details of a validation_failed error with statuscode http.StatusBadRequest.
+func (errs ValidationErrors) Write(w http.ResponseWriter) {
+	writeJSON(w, http.StatusBadRequest, &ErrorResponse{APIError{ErrorCodeValidation, "The webhook didn't validate.", errs}})
+}
+
+func (errs *ValidationErrors) add(field string, message string) {
+	*errs = append(*errs, FieldError{field, message})
+}
+
+// ValidateWebhook checks that a webhook is possible to register. Field-errors is returned as ValidationErrors,
+// while the statuscode and error is only set if we couldn't do the validation (ie. the database failed).
+func ValidateWebhook(webhook Webhook, storage Storage) (ValidationErrors, int, error) {
+	errs := ValidationErrors{}
+
+	if webhook.Kind == WebhookKindEmail {
+		if _, err := mail.ParseAddress(webhook.Email); err != nil {
+			errs.add("email", "is not a valid email-address")
+		} else if strings.ContainsAny(webhook.Email, "\r\n<>,") {
+			errs.add("email", "must be just the address, like name@example.com")
+		}
+		if webhook.WebhookURL != "" {
+			errs.add("webhookurl", "can't be used with kind email")
+		}
+	} else if webhook.WebhookURL == "" {
+		errs.add("webhookurl", "is required")
+	} else if webhookURL, err := url.Parse(webhook.WebhookURL); err != nil {
+		errs.add("webhookurl", "is not a valid url: "+err.Error())
+	} else if webhookURL.Scheme != "http" && webhookURL.Scheme != "https" {
+		errs.add("webhookurl", "must use http or https, not '"+webhookURL.Scheme+"'")
+	} else if webhookURL.Hostname() == "" {
+		errs.add("webhookurl", "must have a host")
+	} else if err := Egress.CheckURL(webhook.WebhookURL); err != nil {
+		errs.add("webhookurl", "is not an allowed destination: "+err.Error())
+	} else if IsChatKind(webhook.Kind) {
+		if problem := checkChatURL(webhook.Kind, webhook.WebhookURL); problem != "" {
+			errs.add("webhookurl", problem)
+		}
+	}
+
+	if webhook.Kind != "" && webhook.Kind != WebhookKindJSON && webhook.Kind != WebhookKindEmail && !IsChatKind(webhook.Kind) {
+		errs.add("kind", "must be one of json, slack, discord and email, not '"+webhook.Kind+"'")
+	} else if (IsChatKind(webhook.Kind) || webhook.Kind == WebhookKindEmail) && (webhook.PayloadTemplate != "" || webhook.ContentType != "") {
+		errs.add("kind", "payloadTemplate and contentType can only be used with kind json")
+	}
+	if webhook.Digest != "" && webhook.Digest != DigestDaily && webhook.Digest != DigestWeekly {
+		errs.add("digest", "must be daily or weekly, not '"+webhook.Digest+"'")
+	} else if webhook.Digest != "" && webhook.PayloadTemplate != "" {
+		errs.add("digest", "can't be used with a payloadTemplate, a digest has its own format")
+	}
+
+	if webhook.TargetCurrency == "" {
+		errs.add("targetCurrency", "is required")
+	} else {
+		latestCurrency, statusCode, err := storage.GetLatestCurrency(webhook.BaseCurrency)
+		if err != nil && statusCode >= 500 {
+			return nil, statusCode, err
+		}
+		if err != nil {
+			errs.add("targetCurrency", "we have no stored rates for base-currency "+webhook.BaseCurrency)
+		} else if _, ok := latestCurrency.Rates[webhook.TargetCurrency]; !ok || webhook.TargetCurrency == webhook.BaseCurrency {
+			errs.add("targetCurrency", "'"+webhook.TargetCurrency+"' is not an accepted rate for base-currency "+webhook.BaseCurrency)
+		}
+	}
+
+	if webhook.MinTriggerValue < 0 {
+		errs.add("minTriggerValue", "can't be negative")
+	}
+	if webhook.MaxTriggerValue < 0 {
+		errs.add("maxTriggerValue", "can't be negative")
+	}
+	if webhook.MinTriggerValue == 0 && webhook.MaxTriggerValue == 0 {
+		errs.add("minTriggerValue", "at least one of minTriggerValue and maxTriggerValue must be set")
+	} else if webhook.MinTriggerValue != 0 && webhook.MaxTriggerValue != 0 && webhook.MinTriggerValue > webhook.MaxTriggerValue {
+		errs.add("maxTriggerValue", "must be larger than or equal to minTriggerValue")
+	}
+
+	templateErr, contentTypeErr := ValidatePayloadTemplate(webhook.PayloadTemplate, webhook.ContentType)
+	if templateErr != nil {
+		errs.add("payloadTemplate", "is not a valid template: "+templateErr.Error())
+	}
+	if contentTypeErr != nil {
+		errs.add("contentType", "is not a valid content-type: "+contentTypeErr.Error())
+	}
+
+	if webhook.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, webhook.ExpiresAt)
+		if err != nil {
+			errs.add("expiresAt", "must be a RFC 3339 timestamp, like 2006-01-02T15:04:05Z")
+		} else if expiresAt.Before(time.Now()) {
+			errs.add("expiresAt", "must be in the future")
+		}
+	}
+
+	return errs, http.StatusOK, nil
+}
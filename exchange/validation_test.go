@@ -0,0 +1,87 @@
+package exchange
+
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "encoding/json"
+
+func setupValidationDatabase() *memoryStorage {
+	Egress = setupTestEgress(nil, nil)
+	db := &memoryStorage{}
+	db.RegisterCurrencyToDatabase(Currency{"EUR", "2017-11-20", map[string]float32{"NOK": 9.6, "SEK": 9.9}})
+	return db
+}
+
+func hasFieldError(errs ValidationErrors, field string) bool {
+	for _, fieldError := range errs {
+		if fieldError.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_ValidateWebhook(t *testing.T) {
+	db := setupValidationDatabase()
+	tests := []struct {
+		webhook Webhook
+		fields  []string // Fields that should have an error, empty if webhook is valid
+	}{
+		{Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1.5, MaxTriggerValue: 2.8}, nil},
+		{Webhook{WebhookURL: "https://example.com/hook", BaseCurrency: "EUR", TargetCurrency: "SEK", MaxTriggerValue: 2.8}, nil},
+		{Webhook{WebhookURL: "", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1.5}, []string{"webhookurl"}},
+		{Webhook{WebhookURL: "ftp://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1.5}, []string{"webhookurl"}},
+		{Webhook{WebhookURL: "http://", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 1.5}, []string{"webhookurl"}},
+		{Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "XYZ", MinTriggerValue: 1.5}, []string{"targetCurrency"}},
+		{Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK"}, []string{"minTriggerValue"}},
+		{Webhook{WebhookURL: "http://example.com", BaseCurrency: "EUR", TargetCurrency: "NOK", MinTriggerValue: 3, MaxTriggerValue: 2}, []string{"maxTriggerValue"}},
+	}
+	for i, test := range tests {
+		errs, statusCode, err := ValidateWebhook(test.webhook, db)
+		if err != nil {
+			t.Error("Test ", i, ": validation failed with statuscode ", statusCode, " and error ", err)
+			continue
+		}
+		if len(errs) != len(test.fields) {
+			t.Error("Test ", i, ": expected errors on ", test.fields, ", but got ", errs)
+			continue
+		}
+		for _, field := range test.fields {
+			if !hasFieldError(errs, field) {
+				t.Error("Test ", i, ": expected an error on field "+field+", but got ", errs)
+			}
+		}
+	}
+}
+
+func Test_Handler_RegisterWebhookValidation(t *testing.T) {
+	DB = setupValidationDatabase()
+	body := `{"webhookurl": "localhost", "baseCurrency": "EUR", "targetCurrency": "XYZ", "minTriggerValue": 3, "maxTriggerValue": 2}`
+	req := httptest.NewRequest("POST", "/exchange", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	RegisterWebhook(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Error("Expected statuscode ", http.StatusBadRequest, ", but got ", w.Code)
+		return
+	}
+	response := struct {
+		Error struct {
+			Code    string           `json:"code"`
+			Details ValidationErrors `json:"details"`
+		} `json:"error"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Error("Couldn't decode validation-errors: ", err)
+		return
+	}
+	if response.Error.Code != ErrorCodeValidation {
+		t.Error("Expected the error-code " + ErrorCodeValidation + ", but got " + response.Error.Code)
+	}
+	for _, field := range []string{"webhookurl", "targetCurrency", "maxTriggerValue"} {
+		if !hasFieldError(response.Error.Details, field) {
+			t.Error("Expected an error on field "+field+", but got ", response.Error.Details)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package exchange
+
+import "crypto/rand"
+import "crypto/subtle"
+import "encoding/hex"
+import "encoding/json"
+import "errors"
+import htmltemplate "html/template"
+import "mime"
+import "net/http"
+import "strconv"
+import "strings"
+
+// The statuses a webhook can have. Webhooks stored before we had statuses have an empty status, and are active.
+const (
+	WebhookPending  = "pending"
+	WebhookActive   = "active"
+	WebhookPaused   = "paused"
+	WebhookDisabled = "disabled" // Too many failed deliveries in a row
+	WebhookExpired  = "expired"  // Never stored, but returned by State() when ExpiresAt has passed
+)
+
+// VerificationRequest - This is the struct which is sent to a webhook to verify that the receiver wants our webhooks.
+// The receiver must answer with statuscode 200, and only the challenge as a text/plain body. Json isn't accepted, so an
+// endpoint which echoes every request back can't be registered by someone else.
+type VerificationRequest struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Challenge string `json:"challenge"`
+}
+
+// NewChallenge returns a random string which the receiver of a webhook must echo back.
+func NewChallenge() string {
+	challenge := make([]byte, 16)
+	if _, err := rand.Read(challenge); err != nil {
+		panic(err) // The system can't give us random numbers, nothing else will work either.
+	}
+	return hex.EncodeToString(challenge)
+}
+
+// VerifyWebhook sends the challenge to the webhook, and marks it as active in the database if the receiver echoes it back.
+// The error tells why the webhook is still pending.
+func VerifyWebhook(webhook Webhook) (int, error) {
+	if webhook.Kind == WebhookKindEmail {
+		return sendEmailChallenge(webhook)
+	} else if IsChatKind(webhook.Kind) {
+		if statusCode, err := verifyChatWebhook(webhook); err != nil {
+			return statusCode, err
+		}
+	} else {
+		body, err := json.Marshal(&VerificationRequest{"verification", webhook.ID, webhook.Challenge})
+		if err != nil {
+			return 0, err
+		}
+		response, err := Delivery.Post(webhook.WebhookURL, "application/json", body)
+		if err != nil {
+			return response.StatusCode, err
+		}
+		if response.StatusCode != http.StatusOK {
+			return response.StatusCode, errors.New("The receiver answered the verification with statuscode " + strconv.Itoa(response.StatusCode) + ", but we expected 200")
+		}
+		if mediaType, _, err := mime.ParseMediaType(response.ContentType); err != nil || mediaType != "text/plain" {
+			return response.StatusCode, errors.New("The receiver answered the verification with content-type '" + response.ContentType + "', but we expected the challenge as text/plain")
+		}
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(string(response.Body))), []byte(webhook.Challenge)) != 1 {
+			return response.StatusCode, errors.New("The receiver didn't answer with the challenge")
+		}
+	}
+
+	return activateWebhook(webhook)
+}
+
+func activateWebhook(webhook Webhook) (int, error) {
+	webhook.Status = WebhookActive
+	webhook.Challenge = ""
+	if statusCode, err := DB.UpdateWebhook(webhook); err != nil {
+		return statusCode, err
+	}
+	return http.StatusOK, nil
+}
+
+// sendEmailChallenge mails the challenge to the address. The webhook stays pending until the user posts the challenge
+// to /exchange/{id}/verify, so nobody can make us send notifications to an address they don't own.
+func sendEmailChallenge(webhook Webhook) (int, error) {
+	if Mail == nil {
+		return http.StatusInternalServerError, errors.New("Email is not configured on this server (SMTP_HOST is not set)")
+	}
+	text := "Someone asked for an email when " + webhook.BaseCurrency + "/" + webhook.TargetCurrency + " is inside a trigger-range.\r\n\r\n" +
+		"To start getting them, POST {\"challenge\": \"" + webhook.Challenge + "\"} to /exchange/" + webhook.ID + "/verify.\r\n" +
+		"If it wasn't you, you can ignore this email.\r\n"
+	html := "<html><body><p>" + strings.Replace(htmltemplate.HTMLEscapeString(text), "\r\n\r\n", "</p><p>", -1) + "</p></body></html>"
+	if err := Mail.Send([]string{webhook.Email}, "Verify your currency alert", text, html); err != nil {
+		return http.StatusExpectationFailed, err
+	}
+	return http.StatusAccepted, errors.New("The challenge has been sent to " + webhook.Email + ", POST it to /exchange/" + webhook.ID + "/verify")
+}
+
+// verifyChatWebhook posts a welcome-message to a chat-tool. They can't echo a challenge, but we only allow their own hosts (see checkChatURL),
+// so it is enough that the chat-tool accepts the message.
+func verifyChatWebhook(webhook Webhook) (int, error) {
+	body, err := buildChatText(webhook.Kind, "This channel will now get a message when "+webhook.BaseCurrency+"/"+webhook.TargetCurrency+" is inside the trigger-range.")
+	if err != nil {
+		return 0, err
+	}
+	response, err := Delivery.Post(webhook.WebhookURL, "application/json", body)
+	if err != nil {
+		return response.StatusCode, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, errors.New("The chat-tool answered the welcome-message with statuscode " + strconv.Itoa(response.StatusCode))
+	}
+	return response.StatusCode, nil
+}
+
+// VerifyWebhookHandler sends the challenge again to a pending webhook, and tells the user if it is now active.
+func VerifyWebhookHandler(w http.ResponseWriter, r *http.Request, id string) {
+	webhook, statusCode, err := DB.GetWebhook(id)
+	if err != nil {
+		writeError(w, statusCode, "Something went wrong when getting a webhook: "+err.Error(), nil)
+		return
+	}
+	if webhook.Status == WebhookPending && webhook.Kind == WebhookKindEmail {
+		answer := VerificationRequest{}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&answer)
+		}
+		if answer.Challenge == "" {
+			writeError(w, http.StatusBadRequest, "POST the challenge from the email as {\"challenge\": \"...\"}", nil)
+			return
+		}
+		if webhook.Challenge == "" || subtle.ConstantTimeCompare([]byte(answer.Challenge), []byte(webhook.Challenge)) != 1 {
+			writeError(w, http.StatusConflict, "The webhook is still pending, the challenge is wrong", nil)
+			return
+		}
+		if statusCode, err := activateWebhook(webhook); err != nil {
+			writeError(w, statusCode, "Something went wrong when updating the webhook: "+err.Error(), nil)
+			return
+		}
+		webhook.Status = WebhookActive
+	} else if webhook.Status == WebhookPending {
+		if webhook.Challenge == "" { // Should not happen, but don't verify with an empty challenge
+			webhook.Challenge = NewChallenge()
+			DB.UpdateWebhook(webhook)
+		}
+		if _, err = VerifyWebhook(webhook); err != nil {
+			writeError(w, http.StatusConflict, "The webhook is still pending, the verification failed: "+err.Error(), nil)
+			return
+		}
+		webhook.Status = WebhookActive
+	}
+
+	writeJSON(w, http.StatusOK, &webhook)
+}
@@ -0,0 +1,118 @@
+package exchange
+
+import "testing"
+import "encoding/json"
+import "io/ioutil"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+
+func registerTestWebhook(t *testing.T, url string) string {
+	body := `{"webhookurl": "` + url + `", "baseCurrency": "EUR", "targetCurrency": "NOK", "minTriggerValue": 1.5, "maxTriggerValue": 20}`
+	w := httptest.NewRecorder()
+	RegisterWebhook(w, httptest.NewRequest("POST", "/exchange", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Error("Expected statuscode 201 when registering webhook, got ", w.Code, ": ", w.Body.String())
+	}
+	created := Webhook{}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.ID == "" {
+		t.Error("Expected the registered webhook with its id as json, got error ", err)
+	}
+	return created.ID
+}
+
+func Test_Handler_RegisterWebhookVerification(t *testing.T) {
+	echo := true
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := VerificationRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		if echo {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(request.Challenge))
+		}
+	}))
+	defer receiver.Close()
+	DB = setupValidationDatabase()
+	Egress = setupTestEgress([]string{"127.0.0.1"}, nil)
+	Delivery = NewDeliveryClient(DefaultDeliveryConfig(), Egress)
+
+	id := registerTestWebhook(t, receiver.URL)
+	webhook, _, _ := DB.GetWebhook(id)
+	if webhook.Status != WebhookActive {
+		t.Error("Expected webhook that echoed the challenge to be active, but it is " + webhook.Status)
+	}
+
+	echo = false
+	pending := NewEventCounter()
+	Events.Subscribe(pending.Handle)
+	id = registerTestWebhook(t, receiver.URL)
+	webhook, _, _ = DB.GetWebhook(id)
+	if webhook.Status != WebhookPending || webhook.Challenge == "" || pending.Counts()["VerificationFailed"] != 1 {
+		t.Error("Expected webhook that didn't echo the challenge to be pending with a challenge, and published, but it is "+webhook.Status, pending.Counts())
+	}
+	w := httptest.NewRecorder()
+	RegisterWebhook(w, httptest.NewRequest("POST", "/exchange/"+id+"/test", strings.NewReader("")))
+	if w.Code != http.StatusConflict {
+		t.Error("Expected a pending webhook not to be test-fired, got statuscode ", w.Code)
+	}
+
+	echo = true
+	w = httptest.NewRecorder()
+	RegisterWebhook(w, httptest.NewRequest("POST", "/exchange/"+id+"/verify", strings.NewReader("")))
+	body, _ := ioutil.ReadAll(w.Body)
+	webhook, _, _ = DB.GetWebhook(id)
+	if w.Code != http.StatusOK || webhook.Status != WebhookActive {
+		t.Error("Expected webhook to be active after verifying again, got statuscode ", w.Code, " and status "+webhook.Status+": "+string(body))
+	}
+}
+
+func Test_VerifyWebhook_Answers(t *testing.T) {
+	var answer func(w http.ResponseWriter, body []byte, challenge string)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request := VerificationRequest{}
+		json.Unmarshal(body, &request)
+		answer(w, body, request.Challenge)
+	}))
+	defer receiver.Close()
+	DB = setupValidationDatabase()
+	Egress = setupTestEgress([]string{"127.0.0.1"}, nil)
+	Delivery = NewDeliveryClient(DefaultDeliveryConfig(), Egress)
+
+	tests := []struct {
+		name     string
+		answer   func(w http.ResponseWriter, body []byte, challenge string)
+		verified bool
+	}{
+		{"the challenge as text/plain", func(w http.ResponseWriter, body []byte, challenge string) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(challenge + "\n"))
+		}, true},
+		{"the request echoed back", func(w http.ResponseWriter, body []byte, challenge string) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+		}, false},
+		{"the request echoed back as text/plain", func(w http.ResponseWriter, body []byte, challenge string) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(body)
+		}, false},
+		{"the challenge as json", func(w http.ResponseWriter, body []byte, challenge string) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"challenge": "` + challenge + `"}`))
+		}, false},
+		{"the challenge as html", func(w http.ResponseWriter, body []byte, challenge string) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(challenge))
+		}, false},
+	}
+	for _, test := range tests {
+		answer = test.answer
+		webhook := Webhook{WebhookURL: receiver.URL, BaseCurrency: "EUR", TargetCurrency: "NOK", Status: WebhookPending, Challenge: NewChallenge()}
+		webhook.ID, _, _ = DB.RegisterWebhookToDatabase(webhook)
+		_, err := VerifyWebhook(webhook)
+		stored, _, _ := DB.GetWebhook(webhook.ID)
+		if (err == nil) != test.verified || (stored.Status == WebhookActive) != test.verified {
+			t.Error("Unexpected verification by a receiver answering with "+test.name+": ", stored.Status, err)
+		}
+	}
+}